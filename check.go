@@ -0,0 +1,162 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/technosophos/dashing/docset"
+	"github.com/urfave/cli/v2"
+)
+
+// expectations describe the entries a docset must, or must not, contain.
+type expectations struct {
+	// Entries that must be found.
+	Entries []expectedEntry `json:"entries"`
+	// The minimum number of entries of each type.
+	MinCounts map[string]int `json:"minCounts"`
+	// Entries that must not be found.
+	Absent []expectedEntry `json:"absent"`
+}
+
+// expectedEntry matches index entries. Empty fields match anything.
+type expectedEntry struct {
+	Name string `json:"name"`
+	Type string `json:"type,omitempty"`
+	// The file the entry is in, relative to the source directory.
+	Path string `json:"path,omitempty"`
+}
+
+func (e expectedEntry) String() string {
+	s := fmt.Sprintf("'%s'", e.Name)
+	if e.Type != "" {
+		s = e.Type + " " + s
+	}
+	if e.Path != "" {
+		s += " in " + e.Path
+	}
+	return s
+}
+
+// matches reports whether found is the entry.
+func (e expectedEntry) matches(found docset.Entry) bool {
+	if e.Name != found.Name || (e.Type != "" && e.Type != found.Type) {
+		return false
+	}
+	return e.Path == "" || filepath.ToSlash(filepath.Clean(e.Path)) == found.File
+}
+
+// check runs the selectors over the source tree and compares the entries
+// they produce with an expectations file.
+func check(c *cli.Context) error {
+	ef := strings.TrimSpace(c.String("expect"))
+	if len(ef) == 0 {
+		ef = "./" + docset.ExpectNames[0]
+		for _, name := range docset.ExpectNames {
+			if _, err := os.Stat(name); err == nil {
+				ef = "./" + name
+				break
+			}
+		}
+	}
+	exp, err := readExpectations(ef)
+	if err != nil {
+		fmt.Printf("Failed to read expectations: %s\n", err)
+		return cli.Exit("", exitConfig)
+	}
+
+	log, err := loggerFromFlags(c)
+	if err != nil {
+		return err
+	}
+	b, closeSource, err := newBuilder(c, log)
+	if err != nil {
+		return err
+	}
+	defer closeSource()
+
+	entries, err := b.Scan()
+	failed := err != nil
+	if failed {
+		log.Errorf("%s", err)
+	}
+
+	diff := exp.compare(entries)
+	for _, line := range diff {
+		fmt.Println(line)
+	}
+	if failed || len(diff) > 0 {
+		fmt.Printf("%d expectation(s) failed in %s\n", len(diff), ef)
+		return cli.Exit("", exitFailure)
+	}
+	fmt.Printf("All expectations in %s met (%d entries)\n", ef, len(entries))
+	return nil
+}
+
+// compare returns a diff-like list of the expectations entries do not
+// meet. Lines starting with "-" are expected but missing, and lines
+// starting with "+" were found but should not have been.
+func (exp *expectations) compare(entries []docset.Entry) []string {
+	var diff []string
+
+	for _, e := range exp.Entries {
+		found := false
+		for _, f := range entries {
+			if e.matches(f) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			diff = append(diff, fmt.Sprintf("- %s", e))
+		}
+	}
+
+	counts := map[string]int{}
+	for _, f := range entries {
+		counts[f.Type]++
+	}
+	types := make([]string, 0, len(exp.MinCounts))
+	for t := range exp.MinCounts {
+		types = append(types, t)
+	}
+	sort.Strings(types)
+	for _, t := range types {
+		if counts[t] < exp.MinCounts[t] {
+			diff = append(diff, fmt.Sprintf("- %s: at least %d entries, found %d", t, exp.MinCounts[t], counts[t]))
+		}
+	}
+
+	for _, e := range exp.Absent {
+		for _, f := range entries {
+			if e.matches(f) {
+				diff = append(diff, fmt.Sprintf("+ %s '%s' at %s", f.Type, f.Name, f.Path))
+			}
+		}
+	}
+	return diff
+}
+
+// readExpectations reads an expectations file in any of the configuration
+// formats.
+func readExpectations(path string) (*expectations, error) {
+	raw, err := docset.DecodeConfigFile(path)
+	if err != nil {
+		return nil, err
+	}
+	data, err := json.Marshal(raw)
+	if err != nil {
+		return nil, err
+	}
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	exp := &expectations{}
+	if err := dec.Decode(exp); err != nil {
+		return nil, fmt.Errorf("%s: %s", path, err)
+	}
+	return exp, nil
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/technosophos/dashing/docset"
+	"github.com/urfave/cli/v2"
+)
+
+// configFile returns the configuration file to use: cf if it is set,
+// otherwise the first of docset.ConfigNames that exists.
+func configFile(cf string) string {
+	cf = strings.TrimSpace(cf)
+	if len(cf) > 0 {
+		return cf
+	}
+	for _, name := range docset.ConfigNames {
+		if _, err := os.Stat(name); err == nil {
+			return "./" + name
+		}
+	}
+	return "./dashing.json"
+}
+
+// configVars returns a lookup for configuration variables. Values given
+// with --set take precedence over the environment.
+func configVars(c *cli.Context) (func(string) (string, bool), error) {
+	vars := map[string]string{}
+	for _, kv := range c.StringSlice("set") {
+		parts := strings.SplitN(kv, "=", 2)
+		if len(parts) != 2 || parts[0] == "" {
+			return nil, fmt.Errorf("invalid --set value '%s' (expected key=value)", kv)
+		}
+		vars[parts[0]] = parts[1]
+	}
+	return func(name string) (string, bool) {
+		if val, ok := vars[name]; ok {
+			return val, true
+		}
+		return os.LookupEnv(name)
+	}, nil
+}
+
+// configTemplates are the commented starting points written by init for
+// formats that allow comments.
+var configTemplates = map[string]string{
+	"yaml": `# Configuration for dashing.
+# See https://github.com/technosophos/dashing for all the options.
+
+# The human-oriented name of the docset.
+name: Dashing
+# Computer-readable name, used for the name of the docset.
+# One word is recommended.
+package: dashing
+# The page Dash opens first, relative to the source directory.
+index: index.html
+
+# CSS selectors, and the Dash entry type of the elements they match.
+#
+# Instead of a type, a selector can map to an object with these keys:
+#   type:        the Dash entry type
+#   attr:        use this attribute instead of the element's text as the name
+#   regexp:      replace matches of this regular expression in the name...
+#   replacement: ...with this text
+#   requiretext: only match elements whose text matches this regexp
+#   matchpath:   only look in files whose path matches this regexp
+# or to a list of such objects.
+selectors:
+  title: Package
+  "dt a": Command
+
+# Names that should never become entries, even if a selector matches them.
+ignore:
+  - ABOUT
+`,
+	"toml": `# Configuration for dashing.
+# See https://github.com/technosophos/dashing for all the options.
+
+# The human-oriented name of the docset.
+name = "Dashing"
+# Computer-readable name, used for the name of the docset.
+# One word is recommended.
+package = "dashing"
+# The page Dash opens first, relative to the source directory.
+index = "index.html"
+
+# Names that should never become entries, even if a selector matches them.
+ignore = ["ABOUT"]
+
+# CSS selectors, and the Dash entry type of the elements they match.
+#
+# Instead of a type, a selector can map to a table with these keys:
+#   type:        the Dash entry type
+#   attr:        use this attribute instead of the element's text as the name
+#   regexp:      replace matches of this regular expression in the name...
+#   replacement: ...with this text
+#   requiretext: only match elements whose text matches this regexp
+#   matchpath:   only look in files whose path matches this regexp
+# or to an array of such tables.
+[selectors]
+title = "Package"
+"dt a" = "Command"
+`,
+}
@@ -0,0 +1,759 @@
+package docset
+
+import (
+	"database/sql"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	_ "github.com/mattn/go-sqlite3"
+	"golang.org/x/net/html"
+)
+
+// Builder builds a docset from a configuration.
+//
+// The zero values of the exported fields build from the current directory
+// into the current directory, the way the dashing command does.
+type Builder struct {
+	// Source holds the files to build from, such as a directory opened
+	// with os.DirFS or OpenSource. Paths in the docset are the paths in
+	// Source; use fs.Sub to build from a directory inside it. Defaults to
+	// the current directory.
+	Source fs.FS
+	// SourceDir is the directory on disk that Source reads, if it is one.
+	// It is used to keep the docset out of the files it is built from.
+	SourceDir string
+	// Output is the directory the docset is written to, as
+	// <package>.docset. Defaults to the configuration's Output, or the
+	// current directory.
+	Output string
+	// AllowOutputInSource allows the docset to be written inside
+	// SourceDir. The docset itself is then skipped when the source files
+	// are walked.
+	AllowOutputInSource bool
+	// Jobs is the number of files to process in parallel. Defaults to 1.
+	Jobs int
+	// Log receives what the build is doing, and any problems. If it is a
+	// ProgressLogger it is also told how far the build has got. Defaults
+	// to discarding everything.
+	Log Logger
+	// OnEntry, if set, is called with every entry found in the files that
+	// are processed, in walk order. Update does not call it for files that
+	// have not changed.
+	OnEntry func(e Entry)
+	// OnSkip, if set, is called with every match that did not become an
+	// entry, and why.
+	OnSkip func(e Entry, reason string)
+
+	config *Dashing
+	links  *linkRewriter
+	// Renders Markdown files, if that is turned on.
+	markdown *markdownRenderer
+}
+
+// Entry is an entry in the docset's search index.
+type Entry struct {
+	Name string
+	Type string
+	// The page and anchor the entry points at, relative to the Documents
+	// directory of the docset.
+	Path string
+	// The file in the Builder's Source the entry was found in.
+	File string
+}
+
+// NewBuilder creates a Builder for a configuration. It fails if the
+// configuration cannot be used.
+func NewBuilder(config *Dashing) (*Builder, error) {
+	if err := config.Compile(); err != nil {
+		return nil, err
+	}
+	links, err := newLinkRewriter(*config)
+	if err != nil {
+		return nil, fmt.Errorf("could not understand link settings: %s", err)
+	}
+	markdown, err := newMarkdownRenderer(*config)
+	if err != nil {
+		return nil, fmt.Errorf("could not set up Markdown: %s", err)
+	}
+	return &Builder{config: config, links: links, markdown: markdown}, nil
+}
+
+// Path returns the path of the docset directory the Builder writes.
+func (b *Builder) Path() string {
+	output := b.Output
+	if output == "" {
+		output = b.config.Output
+	}
+	return filepath.Join(output, b.config.Package+".docset")
+}
+
+// PackagePath returns the path Package writes the docset's archive to,
+// next to the docset.
+func (b *Builder) PackagePath() string {
+	return strings.TrimSuffix(b.Path(), ".docset") + ".tgz"
+}
+
+// FeedPath returns the default path of the docset's feed, next to the
+// package.
+func (b *Builder) FeedPath() string {
+	return strings.TrimSuffix(b.Path(), ".docset") + ".xml"
+}
+
+// Config returns the configuration the Builder was created with.
+func (b *Builder) Config() *Dashing {
+	return b.config
+}
+
+// Build creates the docset from scratch, replacing the search index of any
+// docset already at the output location. The pages of source files that
+// were removed since that docset was built are deleted from it.
+//
+// A file that cannot be read or written does not stop the build; it is
+// logged and recorded in the returned report. The error is only non-nil if
+// the docset could not be built at all, and is then an *InputError or an
+// *OutputError. The report may be non-nil even then.
+func (b *Builder) Build() (*Report, error) {
+	return b.run(true)
+}
+
+// Update brings an existing docset up to date, only processing the files
+// that changed since it was last built. If the docset has no build
+// manifest, or the configuration changed, everything is rebuilt. Errors
+// are as for Build.
+func (b *Builder) Update() (*Report, error) {
+	return b.run(false)
+}
+
+// run runs a build. If fresh is true, the search index is recreated from
+// scratch.
+func (b *Builder) run(fresh bool) (*Report, error) {
+	started := time.Now()
+	r := b.newBuild()
+	log := r.log
+	if _, err := fs.Stat(r.fsys, "."); err != nil {
+		return nil, &InputError{fmt.Errorf("cannot read source: %s", err)}
+	}
+	if err := b.checkOutput(r, true); err != nil {
+		return nil, err
+	}
+
+	if err := os.MkdirAll(r.dest, 0755); err != nil {
+		return nil, &OutputError{fmt.Errorf("failed to create docset: %s", err)}
+	}
+
+	walking := time.Now()
+	paths := r.walk()
+	r.report.Timings.Walk = seconds(time.Since(walking))
+
+	previous, err := loadManifest(r.docset)
+	switch {
+	case fresh:
+	case os.IsNotExist(err):
+		log.Warnf("No build manifest found; rebuilding everything.")
+		fresh = true
+	case err != nil:
+		log.Warnf("Could not read build manifest: %s; rebuilding everything.", err)
+		fresh = true
+	case previous.Config != r.configHash():
+		log.Infof("Configuration changed; rebuilding everything.")
+		fresh = true
+	}
+	if err == nil {
+		if fresh {
+			r.stale = previous
+		} else {
+			r.previous = previous
+			r.moved = addedOrRemoved(previous.Sources, paths)
+		}
+	}
+
+	if err := addPlist(r.docset, r.name, &r.dashing); err != nil {
+		return nil, &OutputError{fmt.Errorf("failed to write Info.plist: %s", err)}
+	}
+	if r.dashing.Icon != nil {
+		if file, err := addIcons(r.dashing.Icon, r.docset); err != nil {
+			r.fail(file, err)
+		}
+	} else if len(r.dashing.Icon32x32) > 0 {
+		if err := addFile(r.dashing.Icon32x32, filepath.Join(r.docset, "icon.png")); err != nil {
+			r.fail(r.dashing.Icon32x32, err)
+		}
+	}
+	if r.markdown != nil && r.dashing.Stylesheet != "" {
+		if err := addFile(r.dashing.Stylesheet, filepath.Join(r.dest, markdownStylesheet)); err != nil {
+			r.fail(r.dashing.Stylesheet, err)
+		}
+	}
+	db, err := initDB(r.docset, fresh)
+	if err != nil {
+		return nil, &OutputError{fmt.Errorf("failed to create database: %s", err)}
+	}
+	defer db.Close()
+	next, err := texasRanger(r, db, paths)
+	r.report.Timings.Total = seconds(time.Since(started))
+	if err != nil {
+		return r.report, &OutputError{fmt.Errorf("failed to write search index: %s", err)}
+	}
+	if err := next.save(r.docset); err != nil {
+		r.fail(manifestPath, writeErr(err))
+	}
+	return r.report, nil
+}
+
+// Scan runs the selectors over the source files without writing anything,
+// and returns the entries they produce, in walk order.
+//
+// Files that cannot be read are logged and skipped, and the returned error
+// says how many there were.
+func (b *Builder) Scan() ([]Entry, error) {
+	r := b.newBuild()
+	if _, err := fs.Stat(r.fsys, "."); err != nil {
+		return nil, &InputError{fmt.Errorf("cannot read source: %s", err)}
+	}
+	if err := b.checkOutput(r, false); err != nil {
+		return nil, err
+	}
+	jobs := newJobs(r.walk())
+	wait := r.start(jobs, r.scan)
+	var entries []Entry
+	for _, j := range jobs {
+		<-j.done
+		if j.err != nil {
+			r.fail(j.path, j.err)
+			continue
+		}
+		for _, ref := range j.refs {
+			entries = append(entries, r.entry(j.path, ref))
+		}
+	}
+	wait()
+	if n := len(r.report.Errors); n > 0 {
+		return entries, &InputError{fmt.Errorf("%d file(s) could not be read", n)}
+	}
+	return entries, nil
+}
+
+// checkOutput works out whether the docset is inside the source
+// directory. If it is, it is left out of the walk, and if it is about to
+// be written it is refused unless that is allowed.
+func (b *Builder) checkOutput(r *builder, writing bool) error {
+	if b.SourceDir == "" {
+		return nil
+	}
+	src, err := realPath(b.SourceDir)
+	if err != nil {
+		return &InputError{err}
+	}
+	out, err := realPath(r.docset)
+	if err != nil {
+		return &OutputError{err}
+	}
+	rel, err := filepath.Rel(src, out)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		// Outside the source, so there is nothing to leave out.
+		r.exclude = []string{}
+		return nil
+	}
+	if writing && !b.AllowOutputInSource {
+		return &OutputError{fmt.Errorf("refusing to write %s inside the source directory %s", r.docset, b.SourceDir)}
+	}
+	rel = filepath.ToSlash(rel)
+	// The package and feed go next to the docset.
+	base := strings.TrimSuffix(rel, ".docset")
+	r.exclude = []string{rel, base + ".tgz", base + ".xml"}
+	return nil
+}
+
+// realPath returns the absolute path of p with symbolic links resolved, as
+// far as p exists.
+func realPath(p string) (string, error) {
+	abs, err := filepath.Abs(p)
+	if err != nil {
+		return "", err
+	}
+	real, err := filepath.EvalSymlinks(abs)
+	if err == nil {
+		return real, nil
+	}
+	if !os.IsNotExist(err) {
+		return "", err
+	}
+	dir, base := filepath.Split(abs)
+	if dir = filepath.Clean(dir); dir == abs {
+		return abs, nil
+	}
+	parent, err := realPath(dir)
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(parent, base), nil
+}
+
+// builder holds the state of a single docset build.
+//
+// Nothing in here is shared between builds or between the files of a build,
+// so the generated docset depends only on the inputs, not on how the work
+// was scheduled across workers.
+type builder struct {
+	// The computer-readable name of the docset.
+	name string
+	// The docset directory.
+	docset string
+	// The Documents directory inside the docset.
+	dest    string
+	dashing Dashing
+	// The source files.
+	fsys fs.FS
+	// The paths of the docset and its package in the source files, if
+	// they are in there. Empty if they are known to be elsewhere, and nil
+	// if it is not known where they are.
+	exclude []string
+	// Rewrites links so they resolve inside the docset.
+	links *linkRewriter
+	// Renders Markdown files, or nil.
+	markdown *markdownRenderer
+	// Selector patterns in a stable order.
+	patterns []string
+	// Entry names that should be ignored.
+	ignoreHash map[string]bool
+	// Number of files to process concurrently.
+	jobs int
+	// The manifest of the previous build, if this is an update.
+	previous *manifest
+	// The manifest of the previous build, if everything is being rebuilt
+	// anyway. It is only used to remove the output of files that are gone.
+	stale *manifest
+	// The paths that were added or removed since the previous build, if
+	// this is an update.
+	moved map[string]bool
+	// Summary of the build.
+	report *Report
+	log    Logger
+
+	onEntry func(Entry)
+	onSkip  func(Entry, string)
+}
+
+// newBuild sets up the state for a build with b's settings.
+func (b *Builder) newBuild() *builder {
+	fsys := b.Source
+	if fsys == nil {
+		fsys = os.DirFS(".")
+	}
+	jobs := b.Jobs
+	if jobs < 1 {
+		jobs = 1
+	}
+	var log Logger = discard{}
+	if b.Log != nil {
+		log = b.Log
+	}
+
+	name := b.config.Package
+	patterns := make([]string, 0, len(b.config.selectors))
+	for pattern := range b.config.selectors {
+		patterns = append(patterns, pattern)
+	}
+	sort.Strings(patterns)
+
+	ignoreHash := make(map[string]bool, len(b.config.Ignore))
+	for _, item := range b.config.Ignore {
+		ignoreHash[item] = true
+	}
+
+	docset := b.Path()
+	return &builder{
+		name:       name,
+		docset:     docset,
+		dest:       filepath.Join(docset, "Contents", "Resources", "Documents"),
+		dashing:    *b.config,
+		fsys:       fsys,
+		links:      b.links.in(fsys),
+		markdown:   b.markdown,
+		patterns:   patterns,
+		ignoreHash: ignoreHash,
+		jobs:       jobs,
+		report:     newReport(name, ""),
+		log:        log,
+		onEntry:    b.OnEntry,
+		onSkip:     b.OnSkip,
+	}
+}
+
+// fail records a file that could not be processed. The build carries on.
+func (b *builder) fail(path string, err error) {
+	b.log.Errorf("Error processing %s: %s", path, err)
+	b.report.fail(path, err)
+}
+
+// warn records a problem that did not stop a file from being processed.
+func (b *builder) warn(format string, args ...interface{}) {
+	msg := fmt.Sprintf(format, args...)
+	b.log.Warnf("%s", msg)
+	b.report.Warnings = append(b.report.Warnings, msg)
+}
+
+// entry turns a reference found in the file at path into an Entry.
+func (b *builder) entry(path string, ref *reference) Entry {
+	return Entry{Name: ref.name, Type: ref.etype, Path: ref.href, File: path}
+}
+
+// progress passes progress on to the logger, if it wants it.
+func (b *builder) progress(done, total int, path string) {
+	if p, ok := b.log.(ProgressLogger); ok {
+		p.Progress(done, total, path)
+	}
+}
+
+func (b *builder) endProgress() {
+	if p, ok := b.log.(ProgressLogger); ok {
+		p.EndProgress()
+	}
+}
+
+// texasRanger is... wait for it... a WALKER!
+//
+// paths are the source files found by walk, in walk order. They are parsed
+// or copied by a pool of b.jobs workers, and the results are indexed in walk order so
+// that the database does not depend on the order in which workers finish.
+//
+// Files whose contents match b.previous are not processed again, and the
+// entries and output of files that have disappeared are removed. On a full
+// rebuild, the output of files that disappeared since b.stale is removed. The
+// returned manifest describes the docset as it now stands.
+func texasRanger(b *builder, db *sql.DB, paths []string) (*manifest, error) {
+	jobs := newJobs(paths)
+	processing := time.Now()
+	wait := b.start(jobs, b.process)
+
+	next := newManifest(b.configHash())
+	next.Sources = paths
+	seen := make(map[string]bool, len(jobs))
+	written := make(map[string]bool, len(jobs))
+	var added, changed, removed, unchanged int
+	ix := newIndexer(db, indexBatchSize)
+	var ixErr error
+	for i, j := range jobs {
+		<-j.done
+		b.progress(i+1, len(jobs), j.path)
+		seen[j.path] = true
+		written[b.outputPath(j.path)] = true
+		old := b.previous.lookup(j.path)
+		if j.unchanged {
+			next.Files[j.path] = old
+			unchanged++
+			b.report.Unchanged++
+			continue
+		}
+		if old != nil {
+			b.log.Infof("Changed: %s", j.path)
+			changed++
+			if ixErr == nil {
+				ixErr = ix.remove(old.references())
+			}
+		} else {
+			if b.previous != nil {
+				b.log.Infof("Added: %s", j.path)
+			}
+			added++
+		}
+		if j.err != nil {
+			b.fail(j.path, j.err)
+			continue
+		}
+		for _, w := range j.warnings {
+			b.warn("%s", w)
+		}
+		if j.copied {
+			b.report.Copied++
+		} else {
+			b.report.Processed++
+		}
+		if ixErr != nil {
+			continue
+		}
+		for _, m := range j.skipped {
+			b.log.Debugf("Skipping entry for '%s' (%s)", m.name, m.skipped)
+			if b.onSkip != nil {
+				b.onSkip(b.entry(j.path, m.reference), m.skipped)
+			}
+		}
+		b.report.ignore(j.path, j.skipped)
+		for _, ref := range j.refs {
+			b.log.Debugf("Match: '%s' is type %s at %s", ref.name, ref.etype, ref.href)
+			if b.onEntry != nil {
+				b.onEntry(b.entry(j.path, ref))
+			}
+		}
+		ixErr = ix.add(j.refs)
+		next.Files[j.path] = newManifestFile(j.hash, j.refs, j.links)
+	}
+	wait()
+	b.endProgress()
+
+	// When everything is rebuilt the index starts out empty, but the output
+	// of files that are gone is still in the docset.
+	last := b.previous
+	if last == nil {
+		last = b.stale
+	}
+	for _, path := range last.paths() {
+		if seen[path] || ixErr != nil {
+			continue
+		}
+		b.log.Infof("Removed: %s", path)
+		removed++
+		b.report.Removed = append(b.report.Removed, path)
+		if b.previous != nil {
+			ixErr = ix.remove(b.previous.Files[path].references())
+		}
+		if written[b.outputPath(path)] {
+			// Another file now has the same output, such as a Markdown
+			// file whose HTML file was removed.
+			continue
+		}
+		if err := os.Remove(filepath.Join(b.dest, filepath.FromSlash(b.outputPath(path)))); err != nil && !os.IsNotExist(err) {
+			b.warn("Failed to remove %s from the docset: %s", path, err)
+		}
+	}
+
+	if ixErr == nil {
+		ixErr = ix.close()
+	}
+	b.report.Timings.Process = seconds(time.Since(processing))
+	b.report.finish(next, ix)
+	if b.previous != nil {
+		b.log.Infof("Updated: %d added, %d changed, %d removed, %d unchanged", added, changed, removed, unchanged)
+	}
+	for _, f := range ix.failures {
+		b.warn("Failed to index '%s' (%s at %s): %s", f.ref.name, f.ref.etype, f.ref.href, f.err)
+	}
+	ix.summary(b.log)
+	return next, ixErr
+}
+
+// walk returns the paths of the source files that belong in the docset.
+func (b *builder) walk() []string {
+	var paths []string
+	fs.WalkDir(b.fsys, ".", func(path string, d fs.DirEntry, err error) error {
+		b.log.Debugf("Reading %s", path)
+		if err != nil {
+			// Carry on with the rest of the tree.
+			b.fail(path, readErr(err))
+			if d != nil && d.IsDir() {
+				return fs.SkipDir
+			}
+			return nil
+		}
+		if b.excluded(path) {
+			b.log.Debugf("Ignoring %s", path)
+			if d.IsDir() {
+				return fs.SkipDir
+			}
+			return nil
+		}
+		if d.IsDir() || ignore(path) {
+			return nil
+		}
+		paths = append(paths, path)
+		return nil
+	})
+	return paths
+}
+
+// excluded reports whether path is the docset being built, its package or
+// its feed. If it is not known where they are, anything named like them is
+// excluded.
+func (b *builder) excluded(path string) bool {
+	if b.exclude == nil {
+		return strings.HasPrefix(path, b.name+".docset") || path == b.name+".tgz"
+	}
+	for _, e := range b.exclude {
+		if path == e {
+			return true
+		}
+	}
+	return false
+}
+
+// start hands jobs to a pool of b.jobs workers, which call fn on each one
+// and then close its done channel. The returned function waits for the
+// workers to finish.
+func (b *builder) start(jobs []*job, fn func(*job)) (wait func()) {
+	queue := make(chan *job)
+	var wg sync.WaitGroup
+	for i := 0; i < b.jobs; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := range queue {
+				fn(j)
+				close(j.done)
+			}
+		}()
+	}
+	go func() {
+		for _, j := range jobs {
+			queue <- j
+		}
+		close(queue)
+	}()
+	return wg.Wait
+}
+
+func newJobs(paths []string) []*job {
+	jobs := make([]*job, len(paths))
+	for i, path := range paths {
+		jobs[i] = &job{path: path, done: make(chan struct{})}
+	}
+	return jobs
+}
+
+// job is a single file handed to a worker.
+type job struct {
+	path string
+	// SHA-256 of the file contents.
+	hash string
+	// Set if the file is the same as in the previous build.
+	unchanged bool
+	// Set if the file was copied rather than parsed.
+	copied  bool
+	refs    []*reference
+	skipped []*match
+	// The paths whose existence the output depends on.
+	links []string
+	// Problems that did not stop the file from being processed.
+	warnings []string
+	err      error
+	// done is closed once the worker has finished with the file.
+	done chan struct{}
+}
+
+// process parses an HTML file, or copies any other file into the docset.
+// Files that have not changed since the previous build are skipped, unless
+// a file their output depends on was added or removed.
+func (b *builder) process(j *job) {
+	if j.hash, j.err = hashFile(b.fsys, j.path); j.err != nil {
+		j.err = readErr(j.err)
+		return
+	}
+	dest := filepath.Join(b.dest, filepath.FromSlash(b.outputPath(j.path)))
+	if old := b.previous.lookup(j.path); old != nil && old.Hash == j.hash && !b.anyMoved(old.Links) {
+		if _, err := os.Stat(dest); err == nil {
+			j.unchanged = true
+			return
+		}
+	}
+	if b.markdown != nil && markdownish(j.path) {
+		// Whether it becomes a page depends on whether there is an HTML
+		// file with the name of the page.
+		j.links = []string{markdownPage(j.path)}
+	}
+	if b.isMarkdown(j.path) {
+		b.log.Debugf("%s looks like Markdown", j.path)
+		j.err = b.parseMarkdown(j)
+		return
+	}
+	if htmlish(j.path) {
+		b.log.Debugf("%s looks like HTML", j.path)
+		j.err = b.parseHTML(j)
+		return
+	}
+	// Or we just copy the file.
+	b.log.Debugf("Copying %s", j.path)
+	j.copied = true
+	j.err = copyFile(b.fsys, j.path, dest)
+}
+
+// scan runs the selectors against an HTML file without writing anything.
+func (b *builder) scan(j *job) {
+	var top *html.Node
+	var err error
+	switch {
+	case b.isMarkdown(j.path):
+		top, err = b.readMarkdown(j.path)
+	case htmlish(j.path):
+		top, err = b.readHTML(j.path)
+	default:
+		return
+	}
+	if err != nil {
+		j.err = err
+		return
+	}
+	page := b.outputPath(j.path)
+	for _, m := range b.extract(page, top, newPage(page, top)) {
+		if m.skipped == "" {
+			j.refs = append(j.refs, m.reference)
+		}
+	}
+}
+
+// ignore returns true if a file should be ignored by dashing.
+func ignore(src string) bool {
+
+	// Skip our own config files.
+	for _, name := range append(ConfigNames, ExpectNames...) {
+		if filepath.Base(src) == name {
+			return true
+		}
+	}
+
+	// Skip VCS dirs.
+	parts := strings.Split(src, "/")
+	for _, p := range parts {
+		switch p {
+		case ".git", ".svn":
+			return true
+		}
+	}
+	return false
+}
+
+// addFile adds a file that is not one of the source files, such as the
+// icon, to the docset.
+func addFile(src, dest string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return readErr(err)
+	}
+	defer in.Close()
+	return writeFile(dest, in)
+}
+
+// copyFile copies a source file to a new destination.
+//
+// Errors are marked as input or output errors.
+func copyFile(fsys fs.FS, src, dest string) error {
+	in, err := fsys.Open(src)
+	if err != nil {
+		return readErr(err)
+	}
+	defer in.Close()
+	return writeFile(dest, in)
+}
+
+// writeFile writes the contents of in to dest, creating its directory if
+// needed. Errors are marked as output errors.
+func writeFile(dest string, in io.Reader) error {
+	if err := os.MkdirAll(filepath.Dir(dest), 0755); err != nil {
+		return writeErr(err)
+	}
+	out, err := os.Create(dest)
+	if err != nil {
+		return writeErr(err)
+	}
+
+	if _, err = io.Copy(out, in); err != nil {
+		out.Close()
+		return writeErr(err)
+	}
+	return writeErr(out.Close())
+}
@@ -0,0 +1,228 @@
+package docset
+
+import (
+	"database/sql"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+	"testing/fstest"
+)
+
+// testConfig returns the configuration of the docset "test".
+func testConfig() *Dashing {
+	return &Dashing{
+		Name:      "Test",
+		Package:   "test",
+		Selectors: map[string]interface{}{"h1": "Guide", "h2": "Function"},
+	}
+}
+
+// testBuilder creates a Builder that builds config from src into a
+// temporary directory.
+func testBuilder(t *testing.T, config *Dashing, src fstest.MapFS) *Builder {
+	t.Helper()
+	b, err := NewBuilder(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b.Source = src
+	b.Output = t.TempDir()
+	return b
+}
+
+// indexEntries returns the entries in the search index of a docset, as
+// "name type path", sorted.
+func indexEntries(t *testing.T, docset string) []string {
+	t.Helper()
+	db, err := sql.Open("sqlite3", filepath.Join(docset, "Contents", "Resources", "docSet.dsidx"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	rows, err := db.Query(`SELECT name, type, path FROM searchIndex`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+	var entries []string
+	for rows.Next() {
+		var name, etype, path string
+		if err := rows.Scan(&name, &etype, &path); err != nil {
+			t.Fatal(err)
+		}
+		entries = append(entries, name+" "+etype+" "+path)
+	}
+	if err := rows.Err(); err != nil {
+		t.Fatal(err)
+	}
+	sort.Strings(entries)
+	return entries
+}
+
+func TestUpdate(t *testing.T) {
+	src := fstest.MapFS{
+		"a.html":     {Data: []byte("<h1>A</h1>")},
+		"b.html":     {Data: []byte("<h1>B</h1><h2>b1</h2>")},
+		"c.html":     {Data: []byte("<h1>C</h1>")},
+		"style.css":  {Data: []byte("body {}")},
+		"guide.html": {Data: []byte("<p>No entries</p>")},
+	}
+	b := testBuilder(t, testConfig(), src)
+	report, err := b.Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Processed != 4 || report.Copied != 1 || report.Entries != 4 {
+		t.Errorf("build: processed %d, copied %d, %d entries", report.Processed, report.Copied, report.Entries)
+	}
+
+	// Change b, remove c, add d, leave the rest alone.
+	src["b.html"] = &fstest.MapFile{Data: []byte("<h1>B</h1><h2>b2</h2>")}
+	delete(src, "c.html")
+	src["d.html"] = &fstest.MapFile{Data: []byte("<h1>D</h1>")}
+	var found []string
+	b.OnEntry = func(e Entry) { found = append(found, e.Name) }
+	report, err = b.Update()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Processed != 2 || report.Copied != 0 || report.Unchanged != 3 {
+		t.Errorf("update: processed %d, copied %d, unchanged %d; want 2, 0, 3", report.Processed, report.Copied, report.Unchanged)
+	}
+	if !reflect.DeepEqual(report.Removed, []string{"c.html"}) {
+		t.Errorf("update removed %v, want [c.html]", report.Removed)
+	}
+	if want := []string{"B", "b2", "D"}; !reflect.DeepEqual(found, want) {
+		t.Errorf("update found entries %v, want %v", found, want)
+	}
+
+	docset := b.Path()
+	want := []string{
+		"A Guide a.html#guide-a",
+		"B Guide b.html#guide-b",
+		"D Guide d.html#guide-d",
+		"b2 Function b.html#function-b2",
+	}
+	if got := indexEntries(t, docset); !reflect.DeepEqual(got, want) {
+		t.Errorf("index after update is\n%q\nwant\n%q", got, want)
+	}
+	documents := filepath.Join(docset, "Contents", "Resources", "Documents")
+	if _, err := os.Stat(filepath.Join(documents, "c.html")); !os.IsNotExist(err) {
+		t.Errorf("c.html was not removed from the docset: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(documents, "d.html")); err != nil {
+		t.Errorf("d.html was not added to the docset: %v", err)
+	}
+
+	// Nothing changed since.
+	report, err = b.Update()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Processed != 0 || report.Unchanged != 5 || len(report.Removed) != 0 {
+		t.Errorf("second update: processed %d, unchanged %d, removed %v", report.Processed, report.Unchanged, report.Removed)
+	}
+	if got := indexEntries(t, docset); !reflect.DeepEqual(got, want) {
+		t.Errorf("index after second update is\n%q\nwant\n%q", got, want)
+	}
+}
+
+func TestUpdateConfigChanged(t *testing.T) {
+	src := fstest.MapFS{"a.html": {Data: []byte("<h1>A</h1><h2>a1</h2>")}}
+	b := testBuilder(t, testConfig(), src)
+	if _, err := b.Build(); err != nil {
+		t.Fatal(err)
+	}
+
+	b.config.Ignore = []string{"a1"}
+	report, err := b.Update()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Processed != 1 || report.Unchanged != 0 {
+		t.Errorf("update after a configuration change: processed %d, unchanged %d", report.Processed, report.Unchanged)
+	}
+	if got, want := indexEntries(t, b.Path()), []string{"A Guide a.html#guide-a"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("index is %q, want %q", got, want)
+	}
+}
+
+func TestUpdateRemovesOutput(t *testing.T) {
+	src := fstest.MapFS{
+		"a.md": {Data: []byte("# A")},
+		"b.md": {Data: []byte("# B")},
+	}
+	config := testConfig()
+	config.Markdown = true
+	b := testBuilder(t, config, src)
+	if _, err := b.Build(); err != nil {
+		t.Fatal(err)
+	}
+
+	delete(src, "b.md")
+	report, err := b.Update()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(report.Removed, []string{"b.md"}) {
+		t.Errorf("update removed %v, want [b.md]", report.Removed)
+	}
+	documents := filepath.Join(b.Path(), "Contents", "Resources", "Documents")
+	if _, err := os.Stat(filepath.Join(documents, "b.html")); !os.IsNotExist(err) {
+		t.Errorf("b.html was not removed from the docset: %v", err)
+	}
+	if got, want := indexEntries(t, b.Path()), []string{"A Guide a.html#a"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("index is %q, want %q", got, want)
+	}
+
+	// The same goes when a configuration change rebuilds everything.
+	delete(src, "a.md")
+	b.config.Ignore = []string{"A"}
+	report, err = b.Update()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(report.Removed, []string{"a.md"}) {
+		t.Errorf("update removed %v, want [a.md]", report.Removed)
+	}
+	if _, err := os.Stat(filepath.Join(documents, "a.html")); !os.IsNotExist(err) {
+		t.Errorf("a.html was not removed from the docset: %v", err)
+	}
+}
+
+func TestUpdateLinkTargets(t *testing.T) {
+	src := fstest.MapFS{
+		"a.html": {Data: []byte(`<a href="https://docs.example.com/v2/b.html">b</a> <a href="sub/">sub</a>`)},
+		"c.html": {Data: []byte(`<a href="a.html">a</a>`)},
+	}
+	config := testConfig()
+	config.SiteURL = "https://docs.example.com/v2/"
+	b := testBuilder(t, config, src)
+	if _, err := b.Build(); err != nil {
+		t.Fatal(err)
+	}
+
+	// The links of a.html now resolve inside the docset.
+	src["b.html"] = &fstest.MapFile{Data: []byte("<h1>B</h1>")}
+	src["sub/index.html"] = &fstest.MapFile{Data: []byte("<h1>Sub</h1>")}
+	report, err := b.Update()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Processed != 3 || report.Unchanged != 1 {
+		t.Errorf("update: processed %d, unchanged %d; want 3, 1", report.Processed, report.Unchanged)
+	}
+	data, err := ioutil.ReadFile(filepath.Join(b.Path(), "Contents", "Resources", "Documents", "a.html"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, link := range []string{`href="b.html"`, `href="sub/index.html"`} {
+		if !strings.Contains(string(data), link) {
+			t.Errorf("a.html does not contain %s:\n%s", link, data)
+		}
+	}
+}
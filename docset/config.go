@@ -0,0 +1,324 @@
+package docset
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v3"
+)
+
+// ConfigNames are the configuration files looked for when none is given,
+// in order of preference.
+var ConfigNames = []string{"dashing.json", "dashing.yaml", "dashing.yml", "dashing.toml"}
+
+// ExpectNames are the expectation files looked for when none is given, in
+// order of preference.
+var ExpectNames = []string{"dashing-expect.json", "dashing-expect.yaml", "dashing-expect.yml", "dashing-expect.toml"}
+
+// ConfigFormat returns the format of a configuration file, based on its
+// extension: "json", "yaml" or "toml".
+func ConfigFormat(path string) string {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		return "yaml"
+	case ".toml":
+		return "toml"
+	}
+	return "json"
+}
+
+// ReadConfig reads a configuration file in any supported format, resolves
+// its extends and include directives, fills in variables using lookup and
+// returns the result as JSON, so that every format decodes into the Dashing
+// struct the same way.
+func ReadConfig(path string, lookup func(string) (string, bool)) ([]byte, error) {
+	conf, err := composeConfig(path, nil)
+	if err != nil {
+		return nil, err
+	}
+	expanded, err := interpolate("$", conf, lookup)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %s", path, err)
+	}
+	data, err := json.Marshal(expanded)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %s", path, err)
+	}
+	return data, nil
+}
+
+// composeConfig reads a configuration file and everything it extends or
+// includes. Relative paths in extends and include are relative to the
+// file they appear in. seen holds the files currently being read, to catch
+// loops.
+//
+// The base configuration named by extends is read first. Included selector
+// fragments are merged on top of it in order, followed by the file itself.
+// See mergeConfig for how values are combined.
+func composeConfig(path string, seen []string) (map[string]interface{}, error) {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return nil, err
+	}
+	for _, s := range seen {
+		if s == abs {
+			return nil, fmt.Errorf("%s extends itself", path)
+		}
+	}
+	seen = append(seen, abs)
+
+	conf, err := DecodeConfigFile(path)
+	if err != nil {
+		return nil, err
+	}
+	dir := filepath.Dir(path)
+	merged := map[string]interface{}{}
+
+	if ext, ok := conf["extends"]; ok {
+		base, ok := ext.(string)
+		if !ok {
+			return nil, fmt.Errorf("%s: extends: expected a string, got %s", path, jsonKind(ext))
+		}
+		if merged, err = composeConfig(relativeTo(dir, base), seen); err != nil {
+			return nil, fmt.Errorf("%s: extends %s: %s", path, base, err)
+		}
+		delete(conf, "extends")
+	}
+
+	if inc, ok := conf["include"]; ok {
+		files, err := stringList(inc)
+		if err != nil {
+			return nil, fmt.Errorf("%s: include: %s", path, err)
+		}
+		for _, f := range files {
+			frag, err := DecodeConfigFile(relativeTo(dir, f))
+			if err != nil {
+				return nil, fmt.Errorf("%s: include %s: %s", path, f, err)
+			}
+			mergeConfig(merged, map[string]interface{}{"selectors": frag})
+		}
+		delete(conf, "include")
+	}
+
+	mergeConfig(merged, conf)
+	return merged, nil
+}
+
+// mergeConfig merges src into dst.
+//
+// Selectors are merged by selector, with src replacing any transforms dst
+// has for the same selector. Ignore and customTypes lists are combined.
+// Every other value in src replaces the one in dst.
+func mergeConfig(dst, src map[string]interface{}) {
+	for k, v := range src {
+		switch k {
+		case "selectors":
+			d, ok1 := dst[k].(map[string]interface{})
+			s, ok2 := v.(map[string]interface{})
+			if !ok1 || !ok2 {
+				dst[k] = v
+				continue
+			}
+			sels := make(map[string]interface{}, len(d)+len(s))
+			for sel, t := range d {
+				sels[sel] = t
+			}
+			for sel, t := range s {
+				sels[sel] = t
+			}
+			dst[k] = sels
+		case "ignore", "customTypes":
+			d, ok1 := dst[k].([]interface{})
+			s, ok2 := v.([]interface{})
+			if !ok1 || !ok2 {
+				dst[k] = v
+				continue
+			}
+			list := append([]interface{}{}, d...)
+			for _, item := range s {
+				if !containsValue(list, item) {
+					list = append(list, item)
+				}
+			}
+			dst[k] = list
+		default:
+			dst[k] = v
+		}
+	}
+}
+
+// DecodeConfigFile reads a single file in any supported format.
+func DecodeConfigFile(path string) (map[string]interface{}, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var raw interface{}
+	switch ConfigFormat(path) {
+	case "yaml":
+		err = yaml.Unmarshal(data, &raw)
+	case "toml":
+		var table map[string]interface{}
+		err = toml.Unmarshal(data, &table)
+		raw = normalizeTOML(table)
+	default:
+		err = json.Unmarshal(data, &raw)
+		if se, ok := err.(*json.SyntaxError); ok {
+			line := 1 + strings.Count(string(data[:se.Offset]), "\n")
+			err = fmt.Errorf("line %d: %s", line, err)
+		}
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %s", path, err)
+	}
+	if raw == nil {
+		// An empty document.
+		return map[string]interface{}{}, nil
+	}
+	conf, ok := raw.(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("failed to parse %s: expected an object, got %s", path, jsonKind(raw))
+	}
+	return conf, nil
+}
+
+// normalizeTOML turns the arrays of tables that TOML decodes as
+// []map[string]interface{} into []interface{}, the way JSON and YAML
+// decode them, so that the rest of the configuration code only has to
+// deal with one form.
+func normalizeTOML(v interface{}) interface{} {
+	switch t := v.(type) {
+	case map[string]interface{}:
+		for k, val := range t {
+			t[k] = normalizeTOML(val)
+		}
+		return t
+	case []map[string]interface{}:
+		out := make([]interface{}, len(t))
+		for i, m := range t {
+			out[i] = normalizeTOML(m)
+		}
+		return out
+	case []interface{}:
+		for i, val := range t {
+			t[i] = normalizeTOML(val)
+		}
+		return t
+	}
+	return v
+}
+
+// variable matches "${NAME}", "${NAME:-default}" and the escape "$$".
+var variable = regexp.MustCompile(`\$\$|\$\{([A-Za-z_][A-Za-z0-9_.-]*)(:-([^}]*))?\}`)
+
+// literalValue reports whether the value of key in the object at path is
+// left alone by interpolate. Regexps and their replacements, in selectors
+// and onlineURL rules, already give "$" a meaning: "${name}" refers to a
+// named group, and "$$" is a literal "$".
+func literalValue(path, key string) bool {
+	switch {
+	case strings.HasPrefix(path, "$.selectors") && path != "$.selectors":
+		switch key {
+		case "regexp", "replacement", "requiretext", "matchpath":
+			return true
+		}
+	case strings.HasPrefix(path, "$.onlineURL"):
+		return key == "regexp" || key == "url"
+	}
+	return false
+}
+
+// interpolate replaces variables in every string value of v. Map keys, such
+// as selectors, are left alone, and so are the values that use regexp
+// syntax, see literalValue. path is the JSON path of v, for errors.
+func interpolate(path string, v interface{}, lookup func(string) (string, bool)) (interface{}, error) {
+	switch t := v.(type) {
+	case string:
+		var missing []string
+		out := variable.ReplaceAllStringFunc(t, func(m string) string {
+			if m == "$$" {
+				return "$"
+			}
+			sub := variable.FindStringSubmatch(m)
+			if val, ok := lookup(sub[1]); ok {
+				return val
+			}
+			if sub[2] != "" {
+				return sub[3]
+			}
+			missing = append(missing, sub[1])
+			return m
+		})
+		if len(missing) > 0 {
+			return nil, fmt.Errorf("%s: undefined variable %s (use --set %s=... or set it in the environment)", path, missing[0], missing[0])
+		}
+		return out, nil
+	case map[string]interface{}:
+		out := make(map[string]interface{}, len(t))
+		for _, k := range sortedKeys(t) {
+			if literalValue(path, k) {
+				out[k] = t[k]
+				continue
+			}
+			val, err := interpolate(jsonPath(path, k), t[k], lookup)
+			if err != nil {
+				return nil, err
+			}
+			out[k] = val
+		}
+		return out, nil
+	case []interface{}:
+		out := make([]interface{}, len(t))
+		for i, item := range t {
+			val, err := interpolate(fmt.Sprintf("%s[%d]", path, i), item, lookup)
+			if err != nil {
+				return nil, err
+			}
+			out[i] = val
+		}
+		return out, nil
+	}
+	return v, nil
+}
+
+// relativeTo resolves path relative to dir, unless it is absolute.
+func relativeTo(dir, path string) string {
+	if filepath.IsAbs(path) {
+		return path
+	}
+	return filepath.Join(dir, path)
+}
+
+// stringList accepts a string or a list of strings.
+func stringList(v interface{}) ([]string, error) {
+	switch t := v.(type) {
+	case string:
+		return []string{t}, nil
+	case []interface{}:
+		list := make([]string, len(t))
+		for i, item := range t {
+			s, ok := item.(string)
+			if !ok {
+				return nil, fmt.Errorf("expected a string, got %s", jsonKind(item))
+			}
+			list[i] = s
+		}
+		return list, nil
+	}
+	return nil, fmt.Errorf("expected a string or a list of strings, got %s", jsonKind(v))
+}
+
+func containsValue(list []interface{}, v interface{}) bool {
+	for _, item := range list {
+		if item == v {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,256 @@
+package docset
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestMergeConfig(t *testing.T) {
+	dst := map[string]interface{}{
+		"name":        "Base",
+		"package":     "base",
+		"selectors":   map[string]interface{}{"h1": "Guide", "h2": "Section"},
+		"ignore":      []interface{}{"ABOUT"},
+		"customTypes": []interface{}{"Widget"},
+	}
+	src := map[string]interface{}{
+		"name":      "Child",
+		"selectors": map[string]interface{}{"h2": map[string]interface{}{"type": "Function"}, "h3": "Method"},
+		"ignore":    []interface{}{"ABOUT", "INDEX"},
+		"allowJS":   true,
+	}
+	mergeConfig(dst, src)
+
+	want := map[string]interface{}{
+		"name":    "Child",
+		"package": "base",
+		"selectors": map[string]interface{}{
+			"h1": "Guide",
+			"h2": map[string]interface{}{"type": "Function"},
+			"h3": "Method",
+		},
+		"ignore":      []interface{}{"ABOUT", "INDEX"},
+		"customTypes": []interface{}{"Widget"},
+		"allowJS":     true,
+	}
+	if !reflect.DeepEqual(dst, want) {
+		t.Errorf("mergeConfig gave\n%v\nwant\n%v", dst, want)
+	}
+}
+
+func TestMergeConfigReplacesMismatchedValues(t *testing.T) {
+	dst := map[string]interface{}{
+		"selectors": map[string]interface{}{"h1": "Guide"},
+		"ignore":    []interface{}{"ABOUT"},
+	}
+	mergeConfig(dst, map[string]interface{}{"selectors": "h1", "ignore": "INDEX"})
+	if dst["selectors"] != "h1" || dst["ignore"] != "INDEX" {
+		t.Errorf("values of another type were not replaced: %v", dst)
+	}
+}
+
+func TestInterpolate(t *testing.T) {
+	vars := map[string]string{"HOST": "docs.example.com", "VERSION": "2.1", "EMPTY": ""}
+	lookup := func(k string) (string, bool) {
+		v, ok := vars[k]
+		return v, ok
+	}
+
+	tests := []struct {
+		in, want string
+	}{
+		{"https://${HOST}/v${VERSION}/", "https://docs.example.com/v2.1/"},
+		{"${MISSING:-fallback}", "fallback"},
+		{"${HOST:-fallback}", "docs.example.com"},
+		{"${EMPTY:-fallback}", ""},
+		{"${MISSING:-}", ""},
+		{"$$1 and $1", "$1 and $1"},
+		{"$${HOST}", "${HOST}"},
+		{"no variables", "no variables"},
+	}
+	for _, tt := range tests {
+		got, err := interpolate("$", tt.in, lookup)
+		if err != nil {
+			t.Errorf("interpolate(%q): %s", tt.in, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("interpolate(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestInterpolateNested(t *testing.T) {
+	lookup := func(k string) (string, bool) { return "x", k == "PREFIX" }
+	in := map[string]interface{}{
+		"selectors": map[string]interface{}{
+			"${PREFIX}": []interface{}{
+				map[string]interface{}{"type": "Command", "attr": "${PREFIX}"},
+			},
+		},
+		"allowJS": true,
+		"ignore":  []interface{}{"${PREFIX}", 3.0},
+	}
+	got, err := interpolate("$", in, lookup)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]interface{}{
+		"selectors": map[string]interface{}{
+			"${PREFIX}": []interface{}{
+				map[string]interface{}{"type": "Command", "attr": "x"},
+			},
+		},
+		"allowJS": true,
+		"ignore":  []interface{}{"x", 3.0},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("interpolate gave\n%v\nwant\n%v", got, want)
+	}
+}
+
+func TestInterpolateUndefined(t *testing.T) {
+	lookup := func(string) (string, bool) { return "", false }
+	in := map[string]interface{}{
+		"selectors": map[string]interface{}{
+			"dt a": []interface{}{
+				map[string]interface{}{"type": "${TYPE}", "replacement": "$1"},
+			},
+		},
+	}
+	_, err := interpolate("$", in, lookup)
+	want := `$.selectors["dt a"][0].type: undefined variable TYPE`
+	if err == nil || !strings.HasPrefix(err.Error(), want) {
+		t.Errorf("got error %v, want one starting with %q", err, want)
+	}
+}
+
+func TestReadConfig(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name, data string) string {
+		p := filepath.Join(dir, name)
+		if err := ioutil.WriteFile(p, []byte(data), 0644); err != nil {
+			t.Fatal(err)
+		}
+		return p
+	}
+	write("base.yaml", `
+name: Base
+package: base
+selectors:
+  h1: Guide
+ignore: [ABOUT]
+`)
+	write("commands.json", `{"dt a": "Command"}`)
+	path := write("dashing.toml", `
+extends = "base.yaml"
+include = ["commands.json"]
+name = "${NAME}"
+ignore = ["INDEX"]
+
+[[selectors."dt code"]]
+type = "Function"
+attr = "${ATTR:-href}"
+regexp = "(?P<name>\\w+)$$"
+replacement = "${name}"
+`)
+
+	lookup := func(k string) (string, bool) { return "Child", k == "NAME" }
+	data, err := ReadConfig(path, lookup)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got map[string]interface{}
+	if err := json.Unmarshal(data, &got); err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]interface{}{
+		"name":    "Child",
+		"package": "base",
+		"selectors": map[string]interface{}{
+			"h1":   "Guide",
+			"dt a": "Command",
+			"dt code": []interface{}{
+				map[string]interface{}{"type": "Function", "attr": "href", "regexp": "(?P<name>\\w+)$$", "replacement": "${name}"},
+			},
+		},
+		"ignore": []interface{}{"ABOUT", "INDEX"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("ReadConfig gave\n%v\nwant\n%v", got, want)
+	}
+}
+
+func TestInterpolateRegexps(t *testing.T) {
+	lookup := func(string) (string, bool) { return "x", true }
+	in := map[string]interface{}{
+		"selectors": map[string]interface{}{
+			"regexp": "${TYPE}",
+			"dt a": map[string]interface{}{
+				"type":        "${TYPE}",
+				"regexp":      "(?P<name>\\w+)$$",
+				"replacement": "${name}",
+				"requiretext": "^$$",
+				"matchpath":   "${DIR}",
+			},
+		},
+		"onlineURL": []interface{}{
+			map[string]interface{}{"regexp": "^(.+)$$", "url": "https://${HOST}/$1"},
+		},
+	}
+	got, err := interpolate("$", in, lookup)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]interface{}{
+		"selectors": map[string]interface{}{
+			"regexp": "x",
+			"dt a": map[string]interface{}{
+				"type":        "x",
+				"regexp":      "(?P<name>\\w+)$$",
+				"replacement": "${name}",
+				"requiretext": "^$$",
+				"matchpath":   "${DIR}",
+			},
+		},
+		"onlineURL": []interface{}{
+			map[string]interface{}{"regexp": "^(.+)$$", "url": "https://${HOST}/$1"},
+		},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("interpolate gave\n%v\nwant\n%v", got, want)
+	}
+}
+
+func TestReadConfigExtendsLoop(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "dashing.json")
+	if err := ioutil.WriteFile(path, []byte(`{"extends": "dashing.json"}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ReadConfig(path, nil); err == nil || !strings.Contains(err.Error(), "extends itself") {
+		t.Errorf("got error %v, want a loop to be reported", err)
+	}
+}
+
+func TestParseConfigKeyCase(t *testing.T) {
+	data := []byte(`{"package": "p", "externalUrl": "https://example.com/", "allowjs": true, "comment": "ignored"}`)
+	d, err := ParseConfig(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d.ExternalURL != "https://example.com/" || !d.AllowJS {
+		t.Errorf("keys differing in case were not taken: externalURL %q, allowJS %v", d.ExternalURL, d.AllowJS)
+	}
+	want := []string{
+		"key 'allowjs' is taken as 'allowJS'; the spelling will not be accepted by dashing validate",
+		"key 'externalUrl' is taken as 'externalURL'; the spelling will not be accepted by dashing validate",
+	}
+	if got := CaseMismatches(data); !reflect.DeepEqual(got, want) {
+		t.Errorf("CaseMismatches gave %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,309 @@
+// Package docset generates Dash docsets from HTML documentation.
+//
+// A docset is described by a Dashing configuration, which is usually read
+// from a dashing.json file with LoadConfig. A Builder then runs the
+// configuration's selectors over a tree of HTML files and writes the
+// docset:
+//
+//	config, err := docset.LoadConfig("dashing.json", os.LookupEnv)
+//	if err != nil {
+//		return err
+//	}
+//	b, err := docset.NewBuilder(config)
+//	if err != nil {
+//		return err
+//	}
+//	b.Source = os.DirFS("build/html")
+//	b.Output = "dist"
+//	report, err := b.Build()
+package docset
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+
+	css "github.com/andybalholm/cascadia"
+)
+
+// Dashing is the configuration of a docset.
+type Dashing struct {
+	// The human-oriented name of the package.
+	Name string `json:"name"`
+	// Computer-readable name. Recommendation is to use one word.
+	Package string `json:"package"`
+	// The location of the index.html file.
+	Index string `json:"index"`
+	// Selectors to match.
+	Selectors map[string]interface{} `json:"selectors"`
+	// Final form of the Selectors field.
+	selectors map[string][]*Transform `json:"-"`
+	// Entries that should be ignored.
+	Ignore []string `json:"ignore"`
+	// A 32x32 pixel PNG image, copied to icon.png.
+	Icon32x32 string `json:"icon32x32"`
+	// The icon, in the sizes Dash uses for normal and Retina screens.
+	// Replaces Icon32x32.
+	Icon    *Icon `json:"icon,omitempty"`
+	AllowJS bool  `json:"allowJS"`
+	// External URL for "Open Online Page"
+	ExternalURL string `json:"externalURL"`
+	// The keyword that searches this docset in Dash, instead of the
+	// package name.
+	Keyword string `json:"keyword,omitempty"`
+	// The keyword editor and launcher plugins use for this docset.
+	PluginKeyword string `json:"pluginKeyword,omitempty"`
+	// Turn full-text search on when the docset is installed.
+	DefaultFTS bool `json:"defaultFTS,omitempty"`
+	// Do not offer full-text search.
+	FTSNotSupported bool `json:"ftsNotSupported,omitempty"`
+	// The URL of a playground for trying out code from the docs.
+	PlayURL string `json:"playURL,omitempty"`
+	// Stop pages from loading anything from the internet.
+	BlocksOnlineResources bool `json:"blocksOnlineResources,omitempty"`
+	// Extra keys for Info.plist. They replace the keys Dashing writes.
+	Plist map[string]interface{} `json:"plist,omitempty"`
+	// The URL the docs were published at. Absolute links into the site are
+	// rewritten to point inside the docset.
+	SiteURL string `json:"siteURL,omitempty"`
+	// Works out the URL of each page online, for pages that are not at
+	// the same path under ExternalURL.
+	OnlineURL *OnlineURL `json:"onlineURL,omitempty"`
+	// What to do with links to pages that are not in the docset: "keep"
+	// (the default) leaves them alone, "online" points them at ExternalURL.
+	ExternalLinks string `json:"externalLinks,omitempty"`
+	// Turn Markdown files into HTML pages.
+	Markdown bool `json:"markdown,omitempty"`
+	// An html/template file for the pages made from Markdown files.
+	PageTemplate string `json:"pageTemplate,omitempty"`
+	// A CSS file for the pages made from Markdown files.
+	Stylesheet string `json:"stylesheet,omitempty"`
+	// Entry types that are deliberately not among the types Dash supports.
+	CustomTypes []string `json:"customTypes,omitempty"`
+	// The version of the documentation, published in the docset's feed.
+	Version string `json:"version,omitempty"`
+	// The URLs the packaged docset is downloaded from, for the feed.
+	FeedURLs []string `json:"feedURLs,omitempty"`
+	// The directory the docset is written to, instead of the current
+	// directory.
+	Output string `json:"output,omitempty"`
+}
+
+// Transform is a description of what should be done with a selector.
+// When the Selectors map is unmarshaled, the values are turned into
+// Transform structs.
+type Transform struct {
+	Type        string
+	Attribute   string         // Use the value of this attribute as basis
+	Regexp      *regexp.Regexp // Perform a replace operation on the text
+	Replacement string
+	RequireText *regexp.Regexp // Require text matches the given regexp
+	MatchPath   *regexp.Regexp // Skip files that don't match this path
+	Selector    css.Selector   // The compiled CSS selector this applies to
+}
+
+// LoadConfig reads a configuration file in any supported format, filling
+// in variables using lookup. See ReadConfig and ParseConfig.
+func LoadConfig(path string, lookup func(string) (string, bool)) (*Dashing, error) {
+	data, err := ReadConfig(path, lookup)
+	if err != nil {
+		return nil, err
+	}
+	return ParseConfig(data)
+}
+
+// ParseConfig decodes a JSON configuration and compiles its selectors.
+//
+// Like encoding/json, it matches keys regardless of case, so that
+// configurations that have always built keep building. See CaseMismatches
+// for finding such keys.
+func ParseConfig(data []byte) (*Dashing, error) {
+	d := &Dashing{}
+	if err := json.Unmarshal(data, d); err != nil {
+		return nil, fmt.Errorf("failed to parse JSON: %s", err)
+	}
+	if err := d.Compile(); err != nil {
+		return nil, err
+	}
+	return d, nil
+}
+
+// CaseMismatches returns a warning for every key of a JSON configuration
+// that only differs in case from a setting. ParseConfig takes them as that
+// setting, but Validate reports them as unknown keys.
+func CaseMismatches(data []byte) []string {
+	var raw map[string]interface{}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return nil
+	}
+	fields := configFields()
+	var warnings []string
+	for _, key := range sortedKeys(raw) {
+		if _, ok := fields[key]; ok {
+			continue
+		}
+		for k := range fields {
+			if strings.EqualFold(k, key) {
+				warnings = append(warnings, fmt.Sprintf("key '%s' is taken as '%s'; the spelling will not be accepted by dashing validate", key, k))
+			}
+		}
+	}
+	return warnings
+}
+
+// Compile turns the raw Selectors into Transforms, compiling every CSS
+// selector once so that mistakes are reported before any output is
+// written. NewBuilder compiles the configuration it is given, so this is
+// only needed to check a configuration without building it. The online URL
+// mapping and plist values are checked too.
+func (d *Dashing) Compile() error {
+	if err := decodeSelectField(d); err != nil {
+		return fmt.Errorf("could not understand selector value: %s", err)
+	}
+	if d.OnlineURL != nil {
+		if err := d.OnlineURL.compile(); err != nil {
+			return err
+		}
+	}
+	if _, err := encodePlist(plistEntries(d.Package, d)); err != nil {
+		return fmt.Errorf("invalid plist value: %s", err)
+	}
+	return nil
+}
+
+// transformKeys are the keys understood in the map form of a selector.
+var transformKeys = []string{"type", "attr", "regexp", "replacement", "requiretext", "matchpath"}
+
+func decodeSingleTransform(val map[string]interface{}) (*Transform, error) {
+	var ttype, trep, attr string
+	var creg, cmatchpath, requireText *regexp.Regexp
+	var err error
+
+	str := func(key string) (string, bool, error) {
+		r, ok := val[key]
+		if !ok {
+			return "", false, nil
+		}
+		s, ok := r.(string)
+		if !ok {
+			return "", false, fmt.Errorf("expected a string for '%s', got %s", key, jsonKind(r))
+		}
+		return s, true, nil
+	}
+	re := func(key string) (*regexp.Regexp, error) {
+		r, ok, err := str(key)
+		if !ok || err != nil {
+			return nil, err
+		}
+		c, err := regexp.Compile(r)
+		if err != nil {
+			return nil, fmt.Errorf("failed to compile regexp '%s': %s", r, err)
+		}
+		return c, nil
+	}
+
+	if attr, _, err = str("attr"); err != nil {
+		return nil, err
+	}
+	if ttype, _, err = str("type"); err != nil {
+		return nil, err
+	}
+	if creg, err = re("regexp"); err != nil {
+		return nil, err
+	}
+	if trep, _, err = str("replacement"); err != nil {
+		return nil, err
+	}
+	if requireText, err = re("requiretext"); err != nil {
+		return nil, err
+	}
+	if cmatchpath, err = re("matchpath"); err != nil {
+		return nil, err
+	}
+	return &Transform{
+		Type:        ttype,
+		Attribute:   attr,
+		Regexp:      creg,
+		Replacement: trep,
+		RequireText: requireText,
+		MatchPath:   cmatchpath,
+	}, nil
+}
+
+// jsonKind describes the JSON type of a decoded value.
+func jsonKind(v interface{}) string {
+	switch v.(type) {
+	case nil:
+		return "null"
+	case string:
+		return "string"
+	case bool:
+		return "boolean"
+	case float64:
+		return "number"
+	case []interface{}:
+		return "array"
+	case map[string]interface{}:
+		return "object"
+	}
+	return fmt.Sprintf("%T", v)
+}
+
+// decodeSelectField turns the raw Selectors into Transforms, compiling
+// every CSS selector once so that mistakes are reported before any output
+// is written.
+func decodeSelectField(d *Dashing) error {
+	d.selectors = make(map[string][]*Transform, len(d.Selectors))
+	patterns := make([]string, 0, len(d.Selectors))
+	for sel := range d.Selectors {
+		patterns = append(patterns, sel)
+	}
+	sort.Strings(patterns)
+
+	for _, sel := range patterns {
+		val := d.Selectors[sel]
+		matcher, err := css.Compile(sel)
+		if err != nil {
+			return fmt.Errorf("invalid CSS selector '%s': %s", sel, err)
+		}
+		var trans *Transform
+		rv := reflect.Indirect(reflect.ValueOf(val))
+		if rv.Kind() == reflect.String {
+			trans = &Transform{
+				Type: val.(string),
+			}
+			d.selectors[sel] = append(d.selectors[sel], trans)
+		} else if rv.Kind() == reflect.Map {
+			val := val.(map[string]interface{})
+			if trans, err = decodeSingleTransform(val); err != nil {
+				return err
+			}
+			d.selectors[sel] = append(d.selectors[sel], trans)
+		} else if rv.Kind() == reflect.Slice {
+			for i := 0; i < rv.Len(); i++ {
+				element, ok := rv.Index(i).Interface().(map[string]interface{})
+				if !ok {
+					return fmt.Errorf("Expected map in list for '%s'. Got %s.", sel, jsonKind(rv.Index(i).Interface()))
+				}
+				if trans, err = decodeSingleTransform(element); err != nil {
+					return err
+				}
+				d.selectors[sel] = append(d.selectors[sel], trans)
+			}
+		} else {
+			return fmt.Errorf("Expected string or map. Kind is %s.", rv.Kind().String())
+		}
+		for _, trans := range d.selectors[sel] {
+			trans.Selector = matcher
+			t, err := normalizeEntryType(trans.Type, d.CustomTypes)
+			if err != nil {
+				return fmt.Errorf("selector '%s': %s", sel, err)
+			}
+			trans.Type = t
+		}
+	}
+	return nil
+}
@@ -0,0 +1,40 @@
+package docset
+
+import "fmt"
+
+// InputError is a failure to read the source files.
+type InputError struct{ Err error }
+
+func (e *InputError) Error() string { return e.Err.Error() }
+func (e *InputError) Unwrap() error { return e.Err }
+
+// OutputError is a failure to write the docset.
+type OutputError struct{ Err error }
+
+func (e *OutputError) Error() string { return e.Err.Error() }
+func (e *OutputError) Unwrap() error { return e.Err }
+
+// readErr marks err as an input error. It returns nil if err is nil.
+func readErr(err error) error {
+	if err == nil {
+		return nil
+	}
+	return &InputError{err}
+}
+
+// writeErr marks err as an output error. It returns nil if err is nil.
+func writeErr(err error) error {
+	if err == nil {
+		return nil
+	}
+	return &OutputError{err}
+}
+
+// FileError is a problem with a single file that did not stop the build.
+type FileError struct {
+	Path string
+	Err  error
+}
+
+func (e *FileError) Error() string { return fmt.Sprintf("%s: %s", e.Path, e.Err) }
+func (e *FileError) Unwrap() error { return e.Err }
@@ -0,0 +1,127 @@
+package docset
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"io/ioutil"
+	"os"
+)
+
+// Feed is a Dash docset feed, which tells Dash where to download a
+// docset and when a new version is available:
+//
+//	<entry>
+//	    <version>2.1</version>
+//	    <url>https://docs.example.com/feeds/mylib.tgz</url>
+//	    <other-versions>
+//	        <version><name>2.0</name></version>
+//	    </other-versions>
+//	</entry>
+type Feed struct {
+	XMLName xml.Name `xml:"entry"`
+	Version string   `xml:"version"`
+	// Mirrors the package can be downloaded from.
+	URLs []string `xml:"url"`
+	// Earlier versions, newest first.
+	OtherVersions []FeedVersion `xml:"other-versions>version"`
+	// Elements Dashing does not know about, kept as they are.
+	Extra []feedElement `xml:",any"`
+}
+
+// FeedVersion is an earlier version listed in a feed.
+type FeedVersion struct {
+	Name string `xml:"name"`
+}
+
+// feedElement is an element of a feed that is passed through untouched.
+type feedElement struct {
+	XMLName xml.Name
+	Attrs   []xml.Attr `xml:",any,attr"`
+	Inner   string     `xml:",innerxml"`
+}
+
+// MarshalXML writes the feed, leaving out other-versions if there are
+// none.
+func (f *Feed) MarshalXML(e *xml.Encoder, start xml.StartElement) error {
+	type otherVersions struct {
+		Versions []FeedVersion `xml:"version"`
+	}
+	out := struct {
+		XMLName       xml.Name       `xml:"entry"`
+		Version       string         `xml:"version"`
+		URLs          []string       `xml:"url"`
+		OtherVersions *otherVersions `xml:"other-versions,omitempty"`
+		Extra         []feedElement  `xml:",any"`
+	}{Version: f.Version, URLs: f.URLs, Extra: f.Extra}
+	if len(f.OtherVersions) > 0 {
+		out.OtherVersions = &otherVersions{f.OtherVersions}
+	}
+	return e.Encode(out)
+}
+
+// ReadFeed reads a feed file.
+func ReadFeed(path string) (*Feed, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	f := &Feed{}
+	if err := xml.Unmarshal(data, f); err != nil {
+		return nil, fmt.Errorf("%s is not a Dash feed: %s", path, err)
+	}
+	return f, nil
+}
+
+// SetVersion makes version the current version of the feed, downloaded
+// from urls. If the feed was for another version, that version is added to
+// the front of OtherVersions.
+func (f *Feed) SetVersion(version string, urls []string) {
+	if f.Version != "" && f.Version != version && !f.hasOtherVersion(f.Version) {
+		f.OtherVersions = append([]FeedVersion{{Name: f.Version}}, f.OtherVersions...)
+	}
+	others := f.OtherVersions[:0]
+	for _, v := range f.OtherVersions {
+		if v.Name != version {
+			others = append(others, v)
+		}
+	}
+	f.OtherVersions = others
+	f.Version = version
+	f.URLs = urls
+}
+
+func (f *Feed) hasOtherVersion(version string) bool {
+	for _, v := range f.OtherVersions {
+		if v.Name == version {
+			return true
+		}
+	}
+	return false
+}
+
+// Write writes the feed to path.
+func (f *Feed) Write(path string) error {
+	data, err := xml.MarshalIndent(f, "", "    ")
+	if err != nil {
+		return err
+	}
+	data = append(data, '\n')
+	return writeFile(path, bytes.NewReader(data))
+}
+
+// UpdateFeed sets the version and URLs of the feed at path, creating it if
+// it does not exist, and returns the feed as written.
+func UpdateFeed(path, version string, urls []string) (*Feed, error) {
+	f, err := ReadFeed(path)
+	if os.IsNotExist(err) {
+		f = &Feed{}
+	} else if err != nil {
+		return nil, &InputError{err}
+	}
+	f.SetVersion(version, urls)
+	if err := f.Write(path); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
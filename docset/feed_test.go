@@ -0,0 +1,89 @@
+package docset
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestFeedSetVersion(t *testing.T) {
+	tests := []struct {
+		name    string
+		version string
+		others  []string
+		set     string
+		want    []string
+	}{
+		{"new feed", "", nil, "1.0", nil},
+		{"new version", "1.0", nil, "1.1", []string{"1.0"}},
+		{"newest first", "1.1", []string{"1.0"}, "2.0", []string{"1.1", "1.0"}},
+		{"same version", "1.1", []string{"1.0"}, "1.1", []string{"1.0"}},
+		{"back to an old version", "1.1", []string{"1.0"}, "1.0", []string{"1.1"}},
+		{"already listed", "1.1", []string{"1.1", "1.0"}, "2.0", []string{"1.1", "1.0"}},
+	}
+	for _, tt := range tests {
+		f := &Feed{Version: tt.version, URLs: []string{"https://old.example.com/d.tgz"}}
+		for _, o := range tt.others {
+			f.OtherVersions = append(f.OtherVersions, FeedVersion{o})
+		}
+		urls := []string{"https://a.example.com/d.tgz", "https://b.example.com/d.tgz"}
+		f.SetVersion(tt.set, urls)
+
+		var others []string
+		for _, o := range f.OtherVersions {
+			others = append(others, o.Name)
+		}
+		if f.Version != tt.set || !reflect.DeepEqual(f.URLs, urls) || !reflect.DeepEqual(others, tt.want) {
+			t.Errorf("%s: got version %s, urls %v, other versions %v; want %s, %v, %v",
+				tt.name, f.Version, f.URLs, others, tt.set, urls, tt.want)
+		}
+	}
+}
+
+func TestUpdateFeed(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "d.xml")
+	if _, err := UpdateFeed(path, "1.0", []string{"https://example.com/d.tgz"}); err != nil {
+		t.Fatal(err)
+	}
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `<entry>
+    <version>1.0</version>
+    <url>https://example.com/d.tgz</url>
+</entry>
+`
+	if string(data) != want {
+		t.Errorf("new feed is\n%s\nwant\n%s", data, want)
+	}
+
+	// Elements Dashing does not know about survive an update.
+	data = []byte(strings.Replace(string(data), "</entry>", "    <note lang=\"en\">keep <b>me</b></note>\n</entry>", 1))
+	if err := ioutil.WriteFile(path, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := UpdateFeed(path, "1.1", []string{"https://example.com/d.tgz"}); err != nil {
+		t.Fatal(err)
+	}
+	data, err = ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want = `<entry>
+    <version>1.1</version>
+    <url>https://example.com/d.tgz</url>
+    <other-versions>
+        <version>
+            <name>1.0</name>
+        </version>
+    </other-versions>
+    <note lang="en">keep <b>me</b></note>
+</entry>
+`
+	if string(data) != want {
+		t.Errorf("updated feed is\n%s\nwant\n%s", data, want)
+	}
+}
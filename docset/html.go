@@ -0,0 +1,681 @@
+package docset
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+
+	css "github.com/andybalholm/cascadia"
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+// lineAttr is added to every element by annotateLines, to remember the
+// line it started on.
+const lineAttr = "data-dashing-line"
+
+func encodeHTMLentities(orig string) string {
+	escaped := new(bytes.Buffer)
+	for _, c := range orig {
+		if point_to_entity[c] == "" {
+			escaped.WriteRune(c)
+		} else {
+			escaped.WriteString(point_to_entity[c])
+		}
+	}
+
+	return escaped.String()
+}
+
+// writeHTML writes a page into the docset. If online is set, the page is
+// marked as being at that URL online.
+func writeHTML(orig, dest string, root *html.Node, online string) error {
+	if online != "" {
+		setOnlineURL(root, online)
+	}
+	dir := filepath.Dir(filepath.FromSlash(orig))
+	base := filepath.Base(filepath.FromSlash(orig))
+	if err := os.MkdirAll(filepath.Join(dest, dir), 0755); err != nil {
+		return err
+	}
+	out, err := os.Create(filepath.Join(dest, dir, base))
+	if err != nil {
+		return err
+	}
+
+	content_bytes := new(bytes.Buffer)
+	html.Render(content_bytes, root)
+	content := encodeHTMLentities(content_bytes.String())
+
+	if _, err = out.WriteString(content); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
+
+func htmlish(filename string) bool {
+	e := strings.ToLower(filepath.Ext(filename))
+	switch e {
+	case ".html", ".htm", ".xhtml", ".html5":
+		return true
+	}
+	return false
+}
+
+type reference struct {
+	name, etype, href string
+}
+
+// page holds the state for a single document while it is being parsed.
+type page struct {
+	path string
+	// Anchor names already in use in this document.
+	anchors map[string]bool
+}
+
+// newPage creates the parse state for a document, reserving every id and
+// anchor name that the document already uses.
+func newPage(path string, top *html.Node) *page {
+	p := &page{path: path, anchors: map[string]bool{}}
+	var walk func(*html.Node)
+	walk = func(n *html.Node) {
+		if n.Type == html.ElementNode {
+			if id := attr(n, "id"); id != "" {
+				p.anchors[id] = true
+			}
+			if n.Data == "a" {
+				if name := attr(n, "name"); name != "" {
+					p.anchors[name] = true
+				}
+			}
+		}
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			walk(c)
+		}
+	}
+	walk(top)
+	return p
+}
+
+// linkSelector matches every element that may carry a link.
+var linkSelector = css.MustCompile("*[href],*[src]")
+
+// parseHTML extracts the entries from an HTML file and writes the file
+// into the docset. The results are recorded in j, as for writePage.
+func (b *builder) parseHTML(j *job) error {
+	top, err := b.readHTML(j.path)
+	if err != nil {
+		return err
+	}
+	return b.writePage(j, j.path, top)
+}
+
+// writePage rewrites the links of a parsed page and extracts its entries,
+// then writes it into the docset at path. The entries, the matches that
+// were skipped and the paths in the source files whose existence decided
+// how links were rewritten are recorded in j.
+func (b *builder) writePage(j *job, path string, top *html.Node) error {
+	p := newPage(path, top)
+
+	links := b.links.tracking()
+	roots := linkSelector.MatchAll(top)
+	for _, node := range roots {
+		for i, attribute := range node.Attr {
+			if "href" == attribute.Key || "src" == attribute.Key {
+				node.Attr[i].Val = links.rewrite(path, attribute.Val)
+			}
+		}
+	}
+	for target := range links.checked {
+		j.links = append(j.links, target)
+	}
+	sort.Strings(j.links)
+
+	j.refs = []*reference{}
+	for _, m := range b.extract(path, top, p) {
+		if m.skipped != "" {
+			j.skipped = append(j.skipped, m)
+			continue
+		}
+		j.refs = append(j.refs, m.reference)
+	}
+	var online string
+	if b.dashing.OnlineURL != nil {
+		var err error
+		if online, err = b.dashing.OnlineURL.URL(path); err != nil {
+			// The page is still useful without it.
+			j.warnings = append(j.warnings, fmt.Sprintf("No online page for %s: %s", path, err))
+			online = ""
+		}
+	}
+	return writeErr(writeHTML(path, b.dest, top, online))
+}
+
+// readHTML parses an HTML file from the source files. Errors are marked as
+// input errors.
+func (b *builder) readHTML(path string) (*html.Node, error) {
+	r, err := b.fsys.Open(path)
+	if err != nil {
+		return nil, readErr(err)
+	}
+	defer r.Close()
+	top, err := html.Parse(r)
+	if err != nil {
+		return nil, readErr(err)
+	}
+	return top, nil
+}
+
+// match is an element matched by a selector.
+type match struct {
+	*reference
+	node *html.Node
+	// Why the element did not become an entry, if it did not.
+	skipped string
+}
+
+// extract runs the selectors against a parsed document. Every matched
+// element gets an anchor and a TOC link, and is returned along with the
+// matches that were skipped.
+func (b *builder) extract(path string, top *html.Node, p *page) []*match {
+	var found []*match
+	for _, pattern := range b.patterns {
+		for _, sel := range b.dashing.selectors[pattern] {
+			// Skip this selector if file path doesn't match
+			if sel.MatchPath != nil && !sel.MatchPath.MatchString(path) {
+				continue
+			}
+
+			for _, n := range sel.Selector.MatchAll(top) {
+				textString := text(n)
+				if sel.RequireText != nil && !sel.RequireText.MatchString(textString) {
+					found = append(found, &match{
+						reference: &reference{name: textString, etype: sel.Type},
+						node:      n,
+						skipped:   fmt.Sprintf("Text not matching given regexp '%v'", sel.RequireText),
+					})
+					continue
+				}
+				var name string
+				if len(sel.Attribute) != 0 {
+					name = attr(n, sel.Attribute)
+				} else {
+					name = textString
+				}
+
+				// Skip things explicitly ignored.
+				if b.ignored(name) {
+					found = append(found, &match{
+						reference: &reference{name: name, etype: sel.Type},
+						node:      n,
+						skipped:   "Ignored by dashing JSON",
+					})
+					continue
+				}
+
+				// If we have a regexp, run it.
+				if sel.Regexp != nil {
+					name = sel.Regexp.ReplaceAllString(name, sel.Replacement)
+				}
+
+				// References we want to track.
+				found = append(found, &match{
+					reference: &reference{name, sel.Type, path + "#" + anchor(n, name, sel.Type, p)},
+					node:      n,
+				})
+				// We need to modify the DOM with a special link to support TOC.
+				n.Parent.InsertBefore(newA(name, sel.Type), n)
+			}
+		}
+	}
+	return found
+}
+
+func (b *builder) ignored(n string) bool {
+	_, ok := b.ignoreHash[n]
+	return ok
+}
+
+func text(node *html.Node) string {
+	var b bytes.Buffer
+	for c := node.FirstChild; c != nil; c = c.NextSibling {
+		if c.Type == html.TextNode {
+			b.WriteString(c.Data)
+		} else if c.Type == html.ElementNode {
+			b.WriteString(text(c))
+		}
+	}
+	return strings.TrimSpace(b.String())
+}
+
+func attr(node *html.Node, key string) string {
+	for _, a := range node.Attr {
+		if a.Key == key {
+			return a.Val
+		}
+	}
+	return ""
+}
+
+// anchor returns the name of the anchor for node, creating one if needed.
+//
+// Existing id attributes and <a name> anchors are reused. Otherwise the
+// anchor is derived from the entry type and name, so it only changes when
+// the entry does. Collisions within the page get a numeric suffix.
+func anchor(node *html.Node, name, etype string, p *page) string {
+	if node.Type == html.ElementNode {
+		if id := attr(node, "id"); id != "" {
+			return id
+		}
+		if node.Data == "a" {
+			if n := attr(node, "name"); n != "" {
+				return n
+			}
+		}
+	}
+	base := slug(etype) + "-" + slug(name)
+	tname := base
+	for i := 2; p.anchors[tname]; i++ {
+		tname = fmt.Sprintf("%s-%d", base, i)
+	}
+	p.anchors[tname] = true
+	link := autolink(tname)
+	node.Parent.InsertBefore(link, node)
+	return tname
+}
+
+// slug turns s into a lowercase string of letters, digits and dashes.
+func slug(s string) string {
+	var b strings.Builder
+	dash := false
+	for _, r := range strings.ToLower(s) {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			if dash && b.Len() > 0 {
+				b.WriteByte('-')
+			}
+			b.WriteRune(r)
+			dash = false
+		} else {
+			dash = true
+		}
+	}
+	if b.Len() == 0 {
+		return "entry"
+	}
+	return b.String()
+}
+
+// autolink creates an A tag for when one is not present in original docs.
+func autolink(target string) *html.Node {
+	return &html.Node{
+		Type:     html.ElementNode,
+		DataAtom: atom.A,
+		Data:     atom.A.String(),
+		Attr: []html.Attribute{
+			html.Attribute{Key: "class", Val: "dashingAutolink"},
+			html.Attribute{Key: "name", Val: target},
+		},
+	}
+}
+
+// newA creates a TOC anchor.
+func newA(name, etype string) *html.Node {
+	name = strings.Replace(url.QueryEscape(name), "+", "%20", -1)
+
+	target := fmt.Sprintf("//apple_ref/cpp/%s/%s", etype, name)
+	return &html.Node{
+		Type:     html.ElementNode,
+		DataAtom: atom.A,
+		Data:     atom.A.String(),
+		Attr: []html.Attribute{
+			html.Attribute{Key: "class", Val: "dashAnchor"},
+			html.Attribute{Key: "name", Val: target},
+		},
+	}
+}
+
+var point_to_entity = map[rune]string{
+	8704: "&forall;",
+	8194: "&ensp;",
+	8195: "&emsp;",
+	8709: "&empty;",
+	8711: "&nabla;",
+	8712: "&isin;",
+	8201: "&thinsp;",
+	8715: "&ni;",
+	8204: "&zwnj;",
+	8205: "&zwj;",
+	8206: "&lrm;",
+	8719: "&prod;",
+	8721: "&sum;",
+	8722: "&minus;",
+	8211: "&ndash;",
+	8212: "&mdash;",
+	8727: "&lowast;",
+	8216: "&lsquo;",
+	8217: "&rsquo;",
+	8730: "&radic;",
+	175:  "&macr;",
+	8220: "&ldquo;",
+	8221: "&rdquo;",
+	8222: "&bdquo;",
+	8224: "&dagger;",
+	8225: "&Dagger;",
+	8226: "&bull;",
+	8230: "&hellip;",
+	8743: "&and;",
+	8744: "&or;",
+	8745: "&cap;",
+	8746: "&cup;",
+	8747: "&int;",
+	8240: "&permil;",
+	8242: "&prime;",
+	8243: "&Prime;",
+	8756: "&there4;",
+	8713: "&notin;",
+	8249: "&lsaquo;",
+	8250: "&rsaquo;",
+	8764: "&sim;",
+	// 62:   "&gt;",	// this is already encoded for us
+	8629: "&crarr;",
+	9824: "&spades;",
+	8260: "&frasl;",
+	8773: "&cong;",
+	8776: "&asymp;",
+	8207: "&rlm;",
+	9829: "&hearts;",
+	8800: "&ne;",
+	8801: "&equiv;",
+	9827: "&clubs;",
+	8804: "&le;",
+	8805: "&ge;",
+	9830: "&diams;",
+	// 38:   "&amp;",	// this is already encoded for us
+	8834: "&sub;",
+	8835: "&sup;",
+	8836: "&nsub;",
+	8838: "&sube;",
+	8839: "&supe;",
+	8853: "&oplus;",
+	8855: "&otimes;",
+	8734: "&infin;",
+	8218: "&sbquo;",
+	8901: "&sdot;",
+	160:  "&nbsp;",
+	161:  "&iexcl;",
+	162:  "&cent;",
+	163:  "&pound;",
+	164:  "&curren;",
+	8869: "&perp;",
+	166:  "&brvbar;",
+	167:  "&sect;",
+	168:  "&uml;",
+	169:  "&copy;",
+	170:  "&ordf;",
+	171:  "&laquo;",
+	8364: "&euro;",
+	173:  "&shy;",
+	174:  "&reg;",
+	8733: "&prop;",
+	176:  "&deg;",
+	177:  "&plusmn;",
+	178:  "&sup2;",
+	179:  "&sup3;",
+	180:  "&acute;",
+	181:  "&micro;",
+	182:  "&para;",
+	183:  "&middot;",
+	184:  "&cedil;",
+	185:  "&sup1;",
+	186:  "&ordm;",
+	187:  "&raquo;",
+	188:  "&frac14;",
+	189:  "&frac12;",
+	190:  "&frac34;",
+	191:  "&iquest;",
+	192:  "&Agrave;",
+	193:  "&Aacute;",
+	194:  "&Acirc;",
+	195:  "&Atilde;",
+	196:  "&Auml;",
+	197:  "&Aring;",
+	198:  "&AElig;",
+	199:  "&Ccedil;",
+	200:  "&Egrave;",
+	201:  "&Eacute;",
+	202:  "&Ecirc;",
+	203:  "&Euml;",
+	204:  "&Igrave;",
+	// 34:   "&quot;",	// this is already encoded
+	206:  "&Icirc;",
+	207:  "&Iuml;",
+	208:  "&ETH;",
+	209:  "&Ntilde;",
+	210:  "&Ograve;",
+	211:  "&Oacute;",
+	212:  "&Ocirc;",
+	213:  "&Otilde;",
+	214:  "&Ouml;",
+	215:  "&times;",
+	216:  "&Oslash;",
+	217:  "&Ugrave;",
+	218:  "&Uacute;",
+	219:  "&Ucirc;",
+	220:  "&Uuml;",
+	221:  "&Yacute;",
+	222:  "&THORN;",
+	223:  "&szlig;",
+	224:  "&agrave;",
+	225:  "&aacute;",
+	226:  "&acirc;",
+	227:  "&atilde;",
+	228:  "&auml;",
+	229:  "&aring;",
+	230:  "&aelig;",
+	231:  "&ccedil;",
+	232:  "&egrave;",
+	205:  "&Iacute;",
+	234:  "&ecirc;",
+	235:  "&euml;",
+	236:  "&igrave;",
+	8658: "&rArr;",
+	238:  "&icirc;",
+	239:  "&iuml;",
+	240:  "&eth;",
+	241:  "&ntilde;",
+	242:  "&ograve;",
+	243:  "&oacute;",
+	244:  "&ocirc;",
+	245:  "&otilde;",
+	246:  "&ouml;",
+	247:  "&divide;",
+	248:  "&oslash;",
+	249:  "&ugrave;",
+	250:  "&uacute;",
+	251:  "&ucirc;",
+	252:  "&uuml;",
+	253:  "&yacute;",
+	254:  "&thorn;",
+	255:  "&yuml;",
+	172:  "&not;",
+	8968: "&lceil;",
+	8969: "&rceil;",
+	8970: "&lfloor;",
+	8971: "&rfloor;",
+	8465: "&image;",
+	8472: "&weierp;",
+	8476: "&real;",
+	8482: "&trade;",
+	732:  "&tilde;",
+	9002: "&rang;",
+	8736: "&ang;",
+	402:  "&fnof;",
+	8706: "&part;",
+	8501: "&alefsym;",
+	710:  "&circ;",
+	338:  "&OElig;",
+	339:  "&oelig;",
+	352:  "&Scaron;",
+	353:  "&scaron;",
+	8593: "&uarr;",
+	// 60:   "&lt;",	// this is already encoded for us
+	8594: "&rarr;",
+	8707: "&exist;",
+	8595: "&darr;",
+	8254: "&oline;",
+	233:  "&eacute;",
+	376:  "&Yuml;",
+	916:  "&Delta;",
+	237:  "&iacute;",
+	8592: "&larr;",
+	913:  "&Alpha;",
+	914:  "&Beta;",
+	915:  "&Gamma;",
+	8596: "&harr;",
+	917:  "&Epsilon;",
+	918:  "&Zeta;",
+	919:  "&Eta;",
+	920:  "&Theta;",
+	921:  "&Iota;",
+	922:  "&Kappa;",
+	923:  "&Lambda;",
+	924:  "&Mu;",
+	925:  "&Nu;",
+	926:  "&Xi;",
+	927:  "&Omicron;",
+	928:  "&Pi;",
+	929:  "&Rho;",
+	931:  "&Sigma;",
+	932:  "&Tau;",
+	933:  "&Upsilon;",
+	934:  "&Phi;",
+	935:  "&Chi;",
+	936:  "&Psi;",
+	937:  "&Omega;",
+	945:  "&alpha;",
+	946:  "&beta;",
+	947:  "&gamma;",
+	948:  "&delta;",
+	949:  "&epsilon;",
+	950:  "&zeta;",
+	951:  "&eta;",
+	952:  "&theta;",
+	953:  "&iota;",
+	954:  "&kappa;",
+	955:  "&lambda;",
+	956:  "&mu;",
+	957:  "&nu;",
+	958:  "&xi;",
+	959:  "&omicron;",
+	960:  "&pi;",
+	961:  "&rho;",
+	962:  "&sigmaf;",
+	963:  "&sigma;",
+	964:  "&tau;",
+	965:  "&upsilon;",
+	966:  "&phi;",
+	967:  "&chi;",
+	968:  "&psi;",
+	969:  "&omega;",
+	9674: "&loz;",
+	8656: "&lArr;",
+	977:  "&thetasym;",
+	978:  "&upsih;",
+	8659: "&dArr;",
+	8660: "&hArr;",
+	982:  "&piv;",
+	165:  "&yen;",
+	8657: "&uArr;",
+	9001: "&lang;",
+}
+
+// Match is an element matched by a selector, as returned by TestFile.
+type Match struct {
+	Entry
+	// The line the element starts on.
+	Line int
+	// Why the element did not become an entry, if it did not.
+	Skipped string
+}
+
+// TestFile runs the selectors against a single HTML document without
+// writing anything, and returns everything they match, including the
+// matches that were skipped. name is the path of the document in the
+// Builder's Source, which selectors with a matchpath are tried against.
+//
+// If Markdown is turned on, a Markdown document is rendered into its page
+// first, as it is when building, and the lines are those of the page.
+func (b *Builder) TestFile(name string, r io.Reader) ([]Match, error) {
+	bd := b.newBuild()
+	page := name
+	if bd.isMarkdown(name) {
+		src, err := ioutil.ReadAll(r)
+		if err != nil {
+			return nil, err
+		}
+		page = markdownPage(name)
+		rendered, err := b.markdown.render(page, src)
+		if err != nil {
+			return nil, fmt.Errorf("failed to render page template: %s", err)
+		}
+		r = bytes.NewReader(rendered)
+	}
+	annotated, err := annotateLines(r)
+	if err != nil {
+		return nil, err
+	}
+	top, err := html.Parse(bytes.NewReader(annotated))
+	if err != nil {
+		return nil, err
+	}
+	var matches []Match
+	for _, m := range bd.extract(page, top, newPage(page, top)) {
+		line, _ := strconv.Atoi(attr(m.node, lineAttr))
+		e := Entry{Name: m.name, Type: m.etype, Path: m.href, File: name}
+		matches = append(matches, Match{e, line, m.skipped})
+	}
+	return matches, nil
+}
+
+// annotateLines adds the line number each element starts on to the
+// element as an attribute, since the HTML parser does not keep track of
+// positions.
+func annotateLines(r io.Reader) ([]byte, error) {
+	src, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	var out bytes.Buffer
+	z := html.NewTokenizer(bytes.NewReader(src))
+	line := 1
+	for {
+		tt := z.Next()
+		if tt == html.ErrorToken {
+			if z.Err() == io.EOF {
+				return out.Bytes(), nil
+			}
+			return nil, z.Err()
+		}
+		raw := z.Raw()
+		if tt == html.StartTagToken || tt == html.SelfClosingTagToken {
+			// Raw is only valid until Token is called.
+			n := bytes.Count(raw, []byte("\n"))
+			tok := z.Token()
+			tok.Attr = append(tok.Attr, html.Attribute{Key: lineAttr, Val: strconv.Itoa(line)})
+			out.WriteString(tok.String())
+			line += n
+			continue
+		}
+		out.Write(raw)
+		line += bytes.Count(raw, []byte("\n"))
+	}
+}
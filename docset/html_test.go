@@ -0,0 +1,85 @@
+package docset
+
+import (
+	"strings"
+	"testing"
+
+	"golang.org/x/net/html"
+)
+
+func TestSlug(t *testing.T) {
+	tests := []struct {
+		in, want string
+	}{
+		{"acpid", "acpid"},
+		{"Foo Bar", "foo-bar"},
+		{"  --leading and trailing--  ", "leading-and-trailing"},
+		{"strings.Builder.WriteString()", "strings-builder-writestring"},
+		{"operator<=", "operator"},
+		{"Größe", "größe"},
+		{"v2.1", "v2-1"},
+		{"", "entry"},
+		{"()", "entry"},
+	}
+	for _, tt := range tests {
+		if got := slug(tt.in); got != tt.want {
+			t.Errorf("slug(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestAnchor(t *testing.T) {
+	doc := `<h2 id="intro">Intro</h2>
+<a name="named">Named</a>
+<h2>Foo Bar</h2>
+<h2>Foo Bar</h2>
+<h2 class="taken">Taken</h2>
+<h2>Foo Bar</h2>`
+	top, err := html.Parse(strings.NewReader(doc))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// An id that a generated anchor would otherwise use.
+	taken := findAll(top, "h2")[3]
+	taken.Attr = append(taken.Attr, html.Attribute{Key: "id", Val: "guide-foo-bar-3"})
+
+	p := newPage("page.html", top)
+	var got []string
+	for _, n := range findAll(top, "h2", "a") {
+		got = append(got, anchor(n, text(n), "Guide", p))
+	}
+	want := []string{"intro", "named", "guide-foo-bar", "guide-foo-bar-2", "guide-foo-bar-3", "guide-foo-bar-4"}
+	if strings.Join(got, " ") != strings.Join(want, " ") {
+		t.Errorf("anchors are %q, want %q", got, want)
+	}
+
+	var links []string
+	for _, n := range findAll(top, "a") {
+		if attr(n, "class") == "dashingAutolink" {
+			links = append(links, attr(n, "name"))
+		}
+	}
+	if strings.Join(links, " ") != "guide-foo-bar guide-foo-bar-2 guide-foo-bar-4" {
+		t.Errorf("anchors added to the page are %q", links)
+	}
+}
+
+// findAll returns the elements with the given tags, in document order.
+func findAll(top *html.Node, tags ...string) []*html.Node {
+	var found []*html.Node
+	var walk func(*html.Node)
+	walk = func(n *html.Node) {
+		if n.Type == html.ElementNode {
+			for _, t := range tags {
+				if n.Data == t {
+					found = append(found, n)
+				}
+			}
+		}
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			walk(c)
+		}
+	}
+	walk(top)
+	return found
+}
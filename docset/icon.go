@@ -0,0 +1,201 @@
+package docset
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"image"
+	"image/png"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+
+	"github.com/srwiley/oksvg"
+	"github.com/srwiley/rasterx"
+	"golang.org/x/image/draw"
+)
+
+// The sizes, in pixels, of icon.png and icon@2x.png.
+const (
+	iconSize   = 16
+	iconSize2x = 32
+)
+
+// Icon is the icon setting. It is either a single image, given as a
+// string, that is scaled to the sizes Dash uses, or an object with an
+// image for each size:
+//
+//	"icon": "logo.svg"
+//	"icon": {"1x": "icon-16.png", "2x": "icon-32.png"}
+//
+// Images are PNG or SVG files. SVG images are drawn at the size needed.
+type Icon struct {
+	// An image to scale to both sizes. A PNG must be square, and at least
+	// 32x32 pixels.
+	Source string `json:"-"`
+	// A 16x16 image for icon.png.
+	X1 string `json:"1x,omitempty"`
+	// A 32x32 image for icon@2x.png.
+	X2 string `json:"2x,omitempty"`
+}
+
+// iconKeys are the keys understood in the object form of the icon.
+var iconKeys = []string{"1x", "2x"}
+
+func (i *Icon) UnmarshalJSON(data []byte) error {
+	var raw interface{}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+	switch v := raw.(type) {
+	case string:
+		*i = Icon{Source: v}
+		return nil
+	case map[string]interface{}:
+		*i = Icon{}
+		for _, key := range sortedKeys(v) {
+			s, ok := v[key].(string)
+			switch {
+			case key != "1x" && key != "2x":
+				return fmt.Errorf("unknown icon size '%s' (expected %s)", key, strings.Join(iconKeys, " or "))
+			case !ok:
+				return fmt.Errorf("icon %s must be a string, not %s", key, jsonKind(v[key]))
+			case key == "1x":
+				i.X1 = s
+			default:
+				i.X2 = s
+			}
+		}
+		if i.X1 == "" && i.X2 == "" {
+			return fmt.Errorf("icon needs at least one of %s", strings.Join(iconKeys, " or "))
+		}
+		return nil
+	}
+	return fmt.Errorf("icon must be a string or an object, not %s", jsonKind(raw))
+}
+
+func (i Icon) MarshalJSON() ([]byte, error) {
+	if i.Source != "" {
+		return json.Marshal(i.Source)
+	}
+	type plain Icon
+	return json.Marshal(plain(i))
+}
+
+// addIcons writes icon.png and icon@2x.png to a docset. An error is
+// returned with the file it is about.
+func addIcons(icon *Icon, docset string) (string, error) {
+	if icon.Source != "" {
+		if err := checkIcon(icon.Source, 0); err != nil {
+			return icon.Source, err
+		}
+	}
+	small, large := icon.X1, icon.X2
+	if small == "" {
+		small = icon.Source
+		if small == "" {
+			small = icon.X2
+		}
+	}
+	if large == "" {
+		large = icon.Source
+	}
+
+	if err := writeIcon(small, filepath.Join(docset, "icon.png"), iconSize, small == icon.X1); err != nil {
+		return small, err
+	}
+	if large == "" {
+		// Only a 1x image was given, and scaling it up would not look any
+		// better than Dash doing so.
+		return "", nil
+	}
+	if err := writeIcon(large, filepath.Join(docset, "icon@2x.png"), iconSize2x, large == icon.X2); err != nil {
+		return large, err
+	}
+	return "", nil
+}
+
+// checkIcon checks that an image can be used for the icon. A PNG must be
+// size x size, or if size is 0, square and big enough to be scaled down to
+// both icon sizes.
+func checkIcon(src string, size int) error {
+	data, err := ioutil.ReadFile(src)
+	if err != nil {
+		return readErr(err)
+	}
+	if isSVG(src) {
+		if _, err := oksvg.ReadIconStream(bytes.NewReader(data)); err != nil {
+			return &InputError{fmt.Errorf("not an SVG image: %s", err)}
+		}
+		return nil
+	}
+	c, err := png.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return &InputError{fmt.Errorf("not a PNG image: %s", err)}
+	}
+	switch {
+	case size != 0 && (c.Width != size || c.Height != size):
+		return &InputError{fmt.Errorf("icon must be %dx%d, not %dx%d", size, size, c.Width, c.Height)}
+	case size == 0 && c.Width != c.Height:
+		return &InputError{fmt.Errorf("icon must be square, not %dx%d", c.Width, c.Height)}
+	case size == 0 && c.Width < iconSize2x:
+		return &InputError{fmt.Errorf("icon must be at least %dx%d, not %dx%d", iconSize2x, iconSize2x, c.Width, c.Height)}
+	}
+	return nil
+}
+
+// writeIcon writes src to dest as a size x size PNG. If exact is set, a
+// PNG must already be that size, and is copied as it is.
+func writeIcon(src, dest string, size int, exact bool) error {
+	data, err := ioutil.ReadFile(src)
+	if err != nil {
+		return readErr(err)
+	}
+	var img image.Image
+	if isSVG(src) {
+		if img, err = rasterizeSVG(data, size); err != nil {
+			return &InputError{err}
+		}
+	} else {
+		if img, err = png.Decode(bytes.NewReader(data)); err != nil {
+			return &InputError{fmt.Errorf("not a PNG image: %s", err)}
+		}
+		b := img.Bounds()
+		if b.Dx() == size && b.Dy() == size {
+			return writeFile(dest, bytes.NewReader(data))
+		}
+		if exact {
+			return &InputError{fmt.Errorf("icon must be %dx%d, not %dx%d", size, size, b.Dx(), b.Dy())}
+		}
+		img = scaleImage(img, size)
+	}
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		return &OutputError{err}
+	}
+	return writeFile(dest, &buf)
+}
+
+func isSVG(name string) bool {
+	return strings.EqualFold(filepath.Ext(name), ".svg")
+}
+
+// scaleImage resizes an image to size x size.
+func scaleImage(img image.Image, size int) image.Image {
+	dst := image.NewNRGBA(image.Rect(0, 0, size, size))
+	draw.CatmullRom.Scale(dst, dst.Bounds(), img, img.Bounds(), draw.Over, nil)
+	return dst
+}
+
+// rasterizeSVG draws an SVG image at size x size.
+func rasterizeSVG(data []byte, size int) (image.Image, error) {
+	icon, err := oksvg.ReadIconStream(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("not an SVG image: %s", err)
+	}
+	icon.SetTarget(0, 0, float64(size), float64(size))
+	dst := image.NewNRGBA(image.Rect(0, 0, size, size))
+	scanner := rasterx.NewScannerGV(size, size, dst, dst.Bounds())
+	icon.Draw(rasterx.NewDasher(size, size, scanner), 1)
+	return dst, nil
+}
@@ -0,0 +1,176 @@
+package docset
+
+import (
+	"database/sql"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// indexBatchSize is the number of entries written per transaction.
+const indexBatchSize = 1000
+
+func initDB(docset string, fresh bool) (*sql.DB, error) {
+	dbname := filepath.Join(docset, "Contents", "Resources", "docSet.dsidx")
+
+	if fresh {
+		os.Remove(dbname)
+	}
+
+	db, err := sql.Open("sqlite3", dbname)
+	if err != nil {
+		return db, err
+	}
+
+	if fresh {
+		if _, err := db.Exec(`CREATE TABLE searchIndex(id INTEGER PRIMARY KEY, name TEXT, type TEXT, path TEXT)`); err != nil {
+			return db, err
+		}
+		if _, err := db.Exec(`CREATE UNIQUE INDEX anchor ON searchIndex (name, type, path)`); err != nil {
+			return db, err
+		}
+	}
+
+	return db, nil
+}
+
+// indexer writes references to the searchIndex table.
+//
+// Inserts go through a prepared statement inside a transaction, which is
+// committed every batch entries. A failed insert does not abort the build;
+// it is recorded and reported by summary.
+type indexer struct {
+	db    *sql.DB
+	batch int
+
+	tx      *sql.Tx
+	stmt    *sql.Stmt
+	del     *sql.Stmt
+	pending int
+
+	// Time spent writing.
+	elapsed time.Duration
+
+	inserted   int
+	deleted    int
+	duplicates int
+	failures   []indexFailure
+}
+
+// indexFailure records an entry that could not be written to the index.
+type indexFailure struct {
+	ref *reference
+	err error
+}
+
+func newIndexer(db *sql.DB, batch int) *indexer {
+	if batch < 1 {
+		batch = 1
+	}
+	return &indexer{db: db, batch: batch}
+}
+
+// add writes the references found in one file.
+//
+// The returned error is only non-nil if the transaction itself failed, in
+// which case nothing more can be written.
+func (ix *indexer) add(refs []*reference) error {
+	defer ix.time(time.Now())
+	for _, ref := range refs {
+		if ix.tx == nil {
+			if err := ix.begin(); err != nil {
+				return err
+			}
+		}
+		res, err := ix.stmt.Exec(ref.name, ref.etype, ref.href)
+		if err != nil {
+			ix.failures = append(ix.failures, indexFailure{ref, err})
+			continue
+		}
+		if n, err := res.RowsAffected(); err == nil && n == 0 {
+			ix.duplicates++
+		} else {
+			ix.inserted++
+		}
+		ix.pending++
+		if ix.pending >= ix.batch {
+			if err := ix.commit(); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// remove deletes references that an earlier build wrote.
+func (ix *indexer) remove(refs []*reference) error {
+	defer ix.time(time.Now())
+	for _, ref := range refs {
+		if ix.tx == nil {
+			if err := ix.begin(); err != nil {
+				return err
+			}
+		}
+		res, err := ix.del.Exec(ref.name, ref.etype, ref.href)
+		if err != nil {
+			ix.failures = append(ix.failures, indexFailure{ref, err})
+			continue
+		}
+		if n, err := res.RowsAffected(); err == nil {
+			ix.deleted += int(n)
+		}
+		ix.pending++
+		if ix.pending >= ix.batch {
+			if err := ix.commit(); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// close commits any outstanding entries.
+func (ix *indexer) close() error {
+	defer ix.time(time.Now())
+	if ix.tx == nil {
+		return nil
+	}
+	return ix.commit()
+}
+
+func (ix *indexer) time(start time.Time) {
+	ix.elapsed += time.Since(start)
+}
+
+func (ix *indexer) begin() error {
+	tx, err := ix.db.Begin()
+	if err != nil {
+		return err
+	}
+	stmt, err := tx.Prepare(`INSERT OR IGNORE INTO searchIndex(name, type, path) VALUES (?,?,?)`)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	del, err := tx.Prepare(`DELETE FROM searchIndex WHERE name = ? AND type = ? AND path = ?`)
+	if err != nil {
+		stmt.Close()
+		tx.Rollback()
+		return err
+	}
+	ix.tx, ix.stmt, ix.del = tx, stmt, del
+	return nil
+}
+
+func (ix *indexer) commit() error {
+	ix.stmt.Close()
+	ix.del.Close()
+	err := ix.tx.Commit()
+	ix.tx, ix.stmt, ix.del, ix.pending = nil, nil, nil, 0
+	return err
+}
+
+// summary logs what was written to the index.
+func (ix *indexer) summary(log Logger) {
+	log.Infof("Indexed %d entries (%d removed, %d duplicates ignored, %d failed)", ix.inserted, ix.deleted, ix.duplicates, len(ix.failures))
+}
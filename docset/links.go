@@ -0,0 +1,220 @@
+package docset
+
+import (
+	"fmt"
+	"io/fs"
+	"net/url"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// Values for Dashing.ExternalLinks.
+const (
+	// Leave links to pages outside the docset as they are.
+	externalKeep = "keep"
+	// Point links to pages outside the docset at the online docs.
+	externalOnline = "online"
+)
+
+// linkRewriter rewrites the href and src attributes of a page so that they
+// resolve inside the docset.
+//
+// Root-absolute links ("/a/b.html"), relative links and absolute links to
+// the site the docs were published at are all resolved against the source
+// tree. Links to files that exist in the source tree become relative links
+// from the page. Links to anything else are either left alone or, in
+// online mode, pointed at the online documentation.
+type linkRewriter struct {
+	// The source files.
+	fsys fs.FS
+	// The URL the docs were published at, if known.
+	site *url.URL
+	// The URL of the online docs, used in online mode.
+	online *url.URL
+	// Whether Markdown files are turned into HTML pages, so links to them
+	// have to point at the pages instead.
+	markdown bool
+	// If not nil, every path whose existence decided how a link was
+	// rewritten is recorded here.
+	checked map[string]bool
+}
+
+// newLinkRewriter creates a linkRewriter for the link settings of a
+// configuration. It has to be bound to the source files with in before it
+// is used.
+func newLinkRewriter(d Dashing) (*linkRewriter, error) {
+	l := &linkRewriter{markdown: d.Markdown}
+	if d.SiteURL != "" {
+		u, err := parseBaseURL(d.SiteURL)
+		if err != nil {
+			return nil, fmt.Errorf("invalid siteURL '%s': %s", d.SiteURL, err)
+		}
+		l.site = u
+	}
+	switch d.ExternalLinks {
+	case "", externalKeep:
+	case externalOnline:
+		base := d.ExternalURL
+		if base == "" {
+			base = d.SiteURL
+		}
+		if base == "" {
+			return nil, fmt.Errorf("externalLinks '%s' requires externalURL or siteURL", externalOnline)
+		}
+		u, err := parseBaseURL(base)
+		if err != nil {
+			return nil, fmt.Errorf("invalid externalURL '%s': %s", base, err)
+		}
+		l.online = u
+	default:
+		return nil, fmt.Errorf("unknown externalLinks value '%s' (expected '%s' or '%s')", d.ExternalLinks, externalKeep, externalOnline)
+	}
+	return l, nil
+}
+
+// in returns a copy of l that rewrites links in the files in fsys.
+func (l *linkRewriter) in(fsys fs.FS) *linkRewriter {
+	c := *l
+	c.fsys = fsys
+	return &c
+}
+
+// tracking returns a copy of l that records the paths it checks in
+// checked, so that a page can be rewritten again when one of them is added
+// or removed.
+func (l *linkRewriter) tracking() *linkRewriter {
+	c := *l
+	c.checked = map[string]bool{}
+	return &c
+}
+
+// exists reports whether a path in the source files names a file. The
+// stylesheet of pages made from Markdown counts as one, although it is
+// added to the docset separately.
+func (l *linkRewriter) exists(name string) bool {
+	if l.checked != nil {
+		l.checked[name] = true
+	}
+	if l.markdown && name == markdownStylesheet {
+		return true
+	}
+	info, err := fs.Stat(l.fsys, name)
+	return err == nil && !info.IsDir()
+}
+
+// parseBaseURL parses an absolute URL and makes sure its path is treated
+// as a directory.
+func parseBaseURL(s string) (*url.URL, error) {
+	u, err := url.Parse(s)
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme == "" || u.Host == "" {
+		return nil, fmt.Errorf("not an absolute URL")
+	}
+	if !strings.HasSuffix(u.Path, "/") {
+		u.Path += "/"
+	}
+	return u, nil
+}
+
+// rewrite returns the value a link on page should have in the docset. page
+// is the path of the page as it was walked.
+func (l *linkRewriter) rewrite(page, link string) string {
+	u, err := url.Parse(link)
+	if err != nil {
+		return link
+	}
+
+	dir := path.Dir(page)
+
+	var target string
+	switch {
+	case u.Scheme != "" || u.Host != "":
+		// Absolute URLs are only ours if they point into the site.
+		if !l.sameSite(u) {
+			return link
+		}
+		if u.Path+"/" == l.site.Path {
+			// The site itself, without the trailing slash.
+			target = ""
+		} else {
+			target = strings.TrimPrefix(u.Path, l.site.Path)
+		}
+	case u.Path == "":
+		// Fragments and queries on the same page.
+		return link
+	case strings.HasPrefix(u.Path, "/"):
+		base := "/"
+		if l.site != nil {
+			base = l.site.Path
+		}
+		switch {
+		case u.Path+"/" == base:
+			// The site itself, without the trailing slash.
+			target = ""
+		case strings.HasPrefix(u.Path, base):
+			target = strings.TrimPrefix(u.Path, base)
+		default:
+			return l.outside(u, link)
+		}
+	default:
+		target = path.Join(dir, u.Path)
+		if target == ".." || strings.HasPrefix(target, "../") {
+			return l.outside(u, link)
+		}
+	}
+
+	target = path.Clean("/" + target)[1:]
+	if target == "" || strings.HasSuffix(u.Path, "/") {
+		target = path.Join(target, "index.html")
+	}
+
+	if !l.exists(target) {
+		if l.online != nil {
+			return l.onlineURL(target, u)
+		}
+		if u.Scheme != "" || !strings.HasPrefix(u.Path, "/") {
+			// Leave links we cannot do anything useful with alone.
+			return link
+		}
+	}
+
+	if l.markdown && markdownish(target) && l.exists(target) {
+		target = markdownPage(target)
+	}
+
+	relative, err := filepath.Rel(filepath.FromSlash(dir), filepath.FromSlash(target))
+	if err != nil {
+		return link
+	}
+	out := &url.URL{Path: filepath.ToSlash(relative), RawQuery: u.RawQuery, Fragment: u.Fragment}
+	return out.String()
+}
+
+// sameSite reports whether an absolute URL points into the site.
+func (l *linkRewriter) sameSite(u *url.URL) bool {
+	if l.site == nil || !strings.EqualFold(u.Host, l.site.Host) {
+		return false
+	}
+	if u.Scheme != "" && u.Scheme != "http" && u.Scheme != "https" {
+		return false
+	}
+	return strings.HasPrefix(u.Path, l.site.Path) || u.Path+"/" == l.site.Path
+}
+
+// outside handles a link that resolves outside of the source tree.
+func (l *linkRewriter) outside(u *url.URL, link string) string {
+	if l.online == nil || l.site == nil {
+		return link
+	}
+	return l.site.ResolveReference(u).String()
+}
+
+// onlineURL points target, a path relative to the source tree, at the
+// online docs.
+func (l *linkRewriter) onlineURL(target string, u *url.URL) string {
+	ref := &url.URL{Path: target, RawQuery: u.RawQuery, Fragment: u.Fragment}
+	return l.online.ResolveReference(ref).String()
+}
@@ -0,0 +1,100 @@
+package docset
+
+import (
+	"testing"
+	"testing/fstest"
+)
+
+func TestRewrite(t *testing.T) {
+	files := fstest.MapFS{
+		"index.html":       {},
+		"guide/index.html": {},
+		"guide/intro.html": {},
+		"guide/setup.md":   {},
+		"api/types.html":   {},
+		"style.css":        {},
+	}
+	configs := map[string]Dashing{
+		"keep": {SiteURL: "https://docs.example.com/v2/"},
+		"online": {
+			SiteURL:       "https://docs.example.com/v2/",
+			ExternalLinks: externalOnline,
+		},
+		"markdown": {Markdown: true},
+	}
+
+	tests := []struct {
+		config, page, link, want string
+	}{
+		// Root-absolute links.
+		{"keep", "guide/intro.html", "/v2/api/types.html#Type", "../api/types.html#Type"},
+		{"keep", "guide/intro.html", "/v2/style.css", "../style.css"},
+		{"keep", "guide/intro.html", "/v2/missing.html", "../missing.html"},
+		{"keep", "guide/intro.html", "/other/page.html", "/other/page.html"},
+		{"markdown", "guide/intro.html", "/api/types.html", "../api/types.html"},
+
+		// Relative links.
+		{"keep", "guide/intro.html", "../api/types.html?v=1", "../api/types.html?v=1"},
+		{"keep", "guide/intro.html", "./index.html", "index.html"},
+		{"keep", "guide/intro.html", "missing.html", "missing.html"},
+		{"keep", "guide/intro.html", "../../outside.html", "../../outside.html"},
+
+		// Absolute links to the site.
+		{"keep", "guide/intro.html", "https://docs.example.com/v2/api/types.html", "../api/types.html"},
+		{"keep", "index.html", "http://DOCS.example.com/v2/guide/intro.html#top", "guide/intro.html#top"},
+		{"keep", "index.html", "https://docs.example.com/v1/api/types.html", "https://docs.example.com/v1/api/types.html"},
+
+		// External links.
+		{"keep", "index.html", "https://golang.org/pkg/", "https://golang.org/pkg/"},
+		{"keep", "index.html", "mailto:docs@example.com", "mailto:docs@example.com"},
+		{"online", "index.html", "https://golang.org/pkg/", "https://golang.org/pkg/"},
+		{"online", "guide/intro.html", "/v2/missing.html", "https://docs.example.com/v2/missing.html"},
+		{"online", "guide/intro.html", "../missing.html#x", "https://docs.example.com/v2/missing.html#x"},
+		{"online", "guide/intro.html", "/other/page.html", "https://docs.example.com/other/page.html"},
+		{"online", "guide/intro.html", "/v2/api/types.html", "../api/types.html"},
+
+		// Fragments and queries on the same page.
+		{"keep", "guide/intro.html", "#install", "#install"},
+		{"online", "guide/intro.html", "#install", "#install"},
+		{"keep", "guide/intro.html", "?page=2", "?page=2"},
+
+		// Directory indexes.
+		{"keep", "guide/intro.html", "/v2/", "../index.html"},
+		{"keep", "index.html", "/v2/guide/", "guide/index.html"},
+		{"keep", "api/types.html", "../guide/#top", "../guide/index.html#top"},
+		{"keep", "index.html", "https://docs.example.com/v2", "index.html"},
+		{"keep", "guide/intro.html", "/v2", "../index.html"},
+		{"keep", "index.html", "/v2#top", "index.html#top"},
+		{"online", "guide/intro.html", "/v2?q=1", "../index.html?q=1"},
+		{"keep", "index.html", "/v21/page.html", "/v21/page.html"},
+
+		// Markdown pages and their stylesheet.
+		{"markdown", "guide/intro.html", "setup.md#install", "setup.html#install"},
+		{"markdown", "guide/intro.html", "missing.md", "missing.md"},
+		{"markdown", "guide/intro.html", "../" + markdownStylesheet, "../" + markdownStylesheet},
+		{"keep", "guide/intro.html", "setup.md", "setup.md"},
+	}
+	for _, tt := range tests {
+		l, err := newLinkRewriter(configs[tt.config])
+		if err != nil {
+			t.Fatalf("%s: %s", tt.config, err)
+		}
+		if got := l.in(files).rewrite(tt.page, tt.link); got != tt.want {
+			t.Errorf("%s: rewrite(%q, %q) = %q, want %q", tt.config, tt.page, tt.link, got, tt.want)
+		}
+	}
+}
+
+func TestNewLinkRewriterErrors(t *testing.T) {
+	tests := []Dashing{
+		{SiteURL: "docs.example.com"},
+		{ExternalLinks: externalOnline},
+		{ExternalLinks: externalOnline, ExternalURL: "/docs/"},
+		{ExternalLinks: "offline"},
+	}
+	for _, d := range tests {
+		if _, err := newLinkRewriter(d); err == nil {
+			t.Errorf("newLinkRewriter(%+v) succeeded, want an error", d)
+		}
+	}
+}
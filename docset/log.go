@@ -0,0 +1,29 @@
+package docset
+
+// Logger receives the messages of a build. Its methods may be called from
+// several goroutines at once.
+type Logger interface {
+	Errorf(format string, args ...interface{})
+	Warnf(format string, args ...interface{})
+	Infof(format string, args ...interface{})
+	Debugf(format string, args ...interface{})
+}
+
+// ProgressLogger is a Logger that can also show how far a build has got.
+// If a Builder's Log implements it, Progress is called after every file.
+type ProgressLogger interface {
+	Logger
+	// Progress reports that done of total files have been handled, the
+	// last of which was path.
+	Progress(done, total int, path string)
+	// EndProgress is called once every file has been handled.
+	EndProgress()
+}
+
+// discard is the Logger used when none is given.
+type discard struct{}
+
+func (discard) Errorf(string, ...interface{}) {}
+func (discard) Warnf(string, ...interface{})  {}
+func (discard) Infof(string, ...interface{})  {}
+func (discard) Debugf(string, ...interface{}) {}
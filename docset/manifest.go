@@ -0,0 +1,177 @@
+package docset
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/fs"
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+)
+
+// manifestPath is where the build manifest is kept, relative to the docset.
+const manifestPath = "Contents/Resources/dashing-manifest.json"
+
+// manifest records what a build produced, so that update can tell which
+// files changed since the last build.
+type manifest struct {
+	// Hash of the configuration the docset was built with. If it changes,
+	// every file has to be processed again.
+	Config string `json:"config"`
+	// Every source file that was successfully processed, by path.
+	Files map[string]*manifestFile `json:"files"`
+	// Every source file the build found, in walk order, whether it could
+	// be processed or not.
+	Sources []string `json:"sources,omitempty"`
+}
+
+// manifestFile records a single source file.
+type manifestFile struct {
+	// SHA-256 of the file contents.
+	Hash string `json:"hash"`
+	// The index entries the file produced.
+	Entries []manifestEntry `json:"entries,omitempty"`
+	// Other paths in the source files whose existence the output depends
+	// on, such as the targets of links. If one of them is added or
+	// removed, the file is processed again.
+	Links []string `json:"links,omitempty"`
+}
+
+// manifestEntry is a row in the searchIndex table.
+type manifestEntry struct {
+	Name string `json:"name"`
+	Type string `json:"type"`
+	Path string `json:"path"`
+}
+
+func newManifest(config string) *manifest {
+	return &manifest{Config: config, Files: map[string]*manifestFile{}}
+}
+
+// loadManifest reads the manifest of an existing docset.
+func loadManifest(docset string) (*manifest, error) {
+	data, err := ioutil.ReadFile(filepath.Join(docset, manifestPath))
+	if err != nil {
+		return nil, err
+	}
+	m := newManifest("")
+	if err := json.Unmarshal(data, m); err != nil {
+		return nil, fmt.Errorf("corrupt build manifest: %s", err)
+	}
+	return m, nil
+}
+
+// save writes the manifest into the docset.
+func (m *manifest) save(docset string) error {
+	data, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(filepath.Join(docset, manifestPath), data, 0644)
+}
+
+// lookup returns the record for path, or nil if there is none.
+func (m *manifest) lookup(path string) *manifestFile {
+	if m == nil {
+		return nil
+	}
+	return m.Files[path]
+}
+
+// paths returns the recorded paths in sorted order.
+func (m *manifest) paths() []string {
+	if m == nil {
+		return nil
+	}
+	paths := make([]string, 0, len(m.Files))
+	for path := range m.Files {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+	return paths
+}
+
+// addedOrRemoved returns the paths that are in only one of before and
+// after.
+func addedOrRemoved(before, after []string) map[string]bool {
+	moved := make(map[string]bool)
+	for _, p := range before {
+		moved[p] = true
+	}
+	for _, p := range after {
+		if moved[p] {
+			delete(moved, p)
+		} else {
+			moved[p] = true
+		}
+	}
+	return moved
+}
+
+// anyMoved reports whether any of paths was added or removed since the
+// previous build.
+func (b *builder) anyMoved(paths []string) bool {
+	for _, p := range paths {
+		if b.moved[p] {
+			return true
+		}
+	}
+	return false
+}
+
+func (f *manifestFile) references() []*reference {
+	refs := make([]*reference, len(f.Entries))
+	for i, e := range f.Entries {
+		refs[i] = &reference{e.Name, e.Type, e.Path}
+	}
+	return refs
+}
+
+func newManifestFile(hash string, refs []*reference, links []string) *manifestFile {
+	f := &manifestFile{Hash: hash, Entries: make([]manifestEntry, len(refs)), Links: links}
+	for i, ref := range refs {
+		f.Entries[i] = manifestEntry{ref.name, ref.etype, ref.href}
+	}
+	return f
+}
+
+// configHash identifies a configuration, so that a build can tell whether
+// the docset was produced with the same settings. extra holds the contents
+// of files the configuration names that change the output, such as the
+// page template.
+func configHash(d Dashing, extra ...string) string {
+	h := sha256.New()
+	data, _ := json.Marshal(d)
+	h.Write(data)
+	for _, e := range extra {
+		h.Write([]byte{0})
+		h.Write([]byte(e))
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// configHash identifies the build's configuration, including the page
+// template, which is named by path in the configuration.
+func (b *builder) configHash() string {
+	if b.markdown != nil && b.markdown.custom != "" {
+		return configHash(b.dashing, b.markdown.custom)
+	}
+	return configHash(b.dashing)
+}
+
+// hashFile returns the SHA-256 of a file's contents.
+func hashFile(fsys fs.FS, path string) (string, error) {
+	f, err := fsys.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
@@ -0,0 +1,192 @@
+package docset
+
+import (
+	"bytes"
+	"fmt"
+	"html/template"
+	"io/fs"
+	"io/ioutil"
+	"path"
+	"strings"
+
+	"github.com/russross/blackfriday/v2"
+	"golang.org/x/net/html"
+)
+
+// defaultPageTemplate wraps the pages made from Markdown files, unless the
+// configuration names another template.
+const defaultPageTemplate = `<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>{{.Title}}</title>
+{{- if .Stylesheet}}
+<link rel="stylesheet" href="{{.Stylesheet}}">
+{{- end}}
+</head>
+<body>
+{{.Content}}
+</body>
+</html>
+`
+
+// markdownStylesheet is where the stylesheet for pages made from Markdown
+// files is put in the docset.
+const markdownStylesheet = "dashing-markdown.css"
+
+// markdownExtensions are the Markdown extensions that are turned on. Headings
+// get IDs, so that they can be linked to and used as anchors.
+const markdownExtensions = blackfriday.CommonExtensions | blackfriday.AutoHeadingIDs
+
+// MarkdownPage is what the page template is executed with for each
+// Markdown file.
+type MarkdownPage struct {
+	// The text of the first level 1 heading, or the file name.
+	Title string
+	// The Markdown rendered as HTML.
+	Content template.HTML
+	// The URL of the stylesheet relative to the page, if there is one.
+	Stylesheet string
+	// The path of the page in the docset, e.g. "guide/intro.html".
+	Path string
+}
+
+// markdownRenderer turns Markdown files into pages.
+type markdownRenderer struct {
+	page *template.Template
+	// The text of the page template, if it was read from a file.
+	custom     string
+	stylesheet bool
+}
+
+// newMarkdownRenderer creates a markdownRenderer for the Markdown settings
+// of a configuration. It returns nil if Markdown is not turned on.
+func newMarkdownRenderer(d Dashing) (*markdownRenderer, error) {
+	if !d.Markdown {
+		return nil, nil
+	}
+	text := defaultPageTemplate
+	var custom string
+	if d.PageTemplate != "" {
+		data, err := ioutil.ReadFile(d.PageTemplate)
+		if err != nil {
+			return nil, err
+		}
+		text, custom = string(data), string(data)
+	}
+	t, err := template.New("page").Parse(text)
+	if err != nil {
+		return nil, fmt.Errorf("invalid page template: %s", err)
+	}
+	return &markdownRenderer{page: t, custom: custom, stylesheet: d.Stylesheet != ""}, nil
+}
+
+// markdownish reports whether a file is Markdown.
+func markdownish(filename string) bool {
+	switch strings.ToLower(path.Ext(filename)) {
+	case ".md", ".markdown", ".mdown":
+		return true
+	}
+	return false
+}
+
+// markdownPage returns the path of the page made from a Markdown file.
+func markdownPage(p string) string {
+	return strings.TrimSuffix(p, path.Ext(p)) + ".html"
+}
+
+// render turns a Markdown file into the page at p.
+func (m *markdownRenderer) render(p string, src []byte) ([]byte, error) {
+	doc := blackfriday.New(blackfriday.WithExtensions(markdownExtensions)).Parse(src)
+	r := blackfriday.NewHTMLRenderer(blackfriday.HTMLRendererParameters{
+		Flags: blackfriday.CommonHTMLFlags,
+	})
+	var content bytes.Buffer
+	doc.Walk(func(n *blackfriday.Node, entering bool) blackfriday.WalkStatus {
+		return r.RenderNode(&content, n, entering)
+	})
+
+	page := MarkdownPage{
+		Title:   markdownTitle(doc),
+		Content: template.HTML(content.String()),
+		Path:    p,
+	}
+	if page.Title == "" {
+		base := path.Base(p)
+		page.Title = strings.TrimSuffix(base, path.Ext(base))
+	}
+	if m.stylesheet {
+		page.Stylesheet = strings.Repeat("../", strings.Count(p, "/")) + markdownStylesheet
+	}
+	var out bytes.Buffer
+	if err := m.page.Execute(&out, page); err != nil {
+		return nil, err
+	}
+	return out.Bytes(), nil
+}
+
+// markdownTitle returns the text of the first level 1 heading.
+func markdownTitle(doc *blackfriday.Node) string {
+	var title strings.Builder
+	var heading *blackfriday.Node
+	doc.Walk(func(n *blackfriday.Node, entering bool) blackfriday.WalkStatus {
+		switch {
+		case heading == nil && entering && n.Type == blackfriday.Heading && n.Level == 1:
+			heading = n
+		case heading != nil && !entering && n == heading:
+			return blackfriday.Terminate
+		case heading != nil && entering && (n.Type == blackfriday.Text || n.Type == blackfriday.Code):
+			title.Write(n.Literal)
+		}
+		return blackfriday.GoToNext
+	})
+	return strings.TrimSpace(title.String())
+}
+
+// isMarkdown reports whether a source file is turned into a page. A
+// Markdown file is copied as it is if there is already an HTML file with
+// the name of its page.
+func (b *builder) isMarkdown(p string) bool {
+	if b.markdown == nil || !markdownish(p) {
+		return false
+	}
+	_, err := fs.Stat(b.fsys, markdownPage(p))
+	return err != nil
+}
+
+// outputPath returns the path a source file is written to in the docset.
+func (b *builder) outputPath(p string) string {
+	if b.isMarkdown(p) {
+		return markdownPage(p)
+	}
+	return p
+}
+
+// readMarkdown renders a Markdown file from the source files into a page,
+// and parses it. Errors are marked as input errors.
+func (b *builder) readMarkdown(p string) (*html.Node, error) {
+	src, err := fs.ReadFile(b.fsys, p)
+	if err != nil {
+		return nil, readErr(err)
+	}
+	page, err := b.markdown.render(markdownPage(p), src)
+	if err != nil {
+		return nil, &InputError{fmt.Errorf("failed to render page template: %s", err)}
+	}
+	top, err := html.Parse(bytes.NewReader(page))
+	if err != nil {
+		return nil, readErr(err)
+	}
+	return top, nil
+}
+
+// parseMarkdown turns a Markdown file into a page, extracts the entries
+// from it, and writes it into the docset. The results are recorded in j,
+// as for writePage.
+func (b *builder) parseMarkdown(j *job) error {
+	top, err := b.readMarkdown(j.path)
+	if err != nil {
+		return err
+	}
+	return b.writePage(j, markdownPage(j.path), top)
+}
@@ -0,0 +1,208 @@
+package docset
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"path"
+	"regexp"
+	"strings"
+	"text/template"
+
+	"golang.org/x/net/html"
+)
+
+// OnlineURL is the onlineURL setting, which works out the URL of each page
+// online so that Dash's "Open Online Page" finds it even when the paths on
+// the site differ from the paths in the docset.
+//
+// It is either a template, given as a string, that is executed for every
+// page with an OnlinePage:
+//
+//	"onlineURL": "https://docs.example.com/v2/{{.Dir}}{{.Name}}/"
+//
+// or a list of rules, given as an object or an array of objects. The
+// first rule whose regexp matches the page's path gives the URL, with $1
+// and the like replaced with the regexp's groups. Pages that no rule
+// matches fall back to externalURL.
+//
+//	"onlineURL": [{"regexp": "^api/(.+)\\.html$", "url": "https://docs.example.com/reference/$1"}]
+type OnlineURL struct {
+	Template string
+	Rules    []OnlineURLRule
+	tmpl     *template.Template
+}
+
+// OnlineURLRule maps the pages whose path matches Regexp to URL.
+type OnlineURLRule struct {
+	Regexp string `json:"regexp"`
+	URL    string `json:"url"`
+	re     *regexp.Regexp
+}
+
+// OnlinePage describes a page to an onlineURL template.
+type OnlinePage struct {
+	// The path of the page in the docset, e.g. "api/types.html".
+	Path string
+	// The directory of the page with a trailing slash, "api/", or "" at
+	// the top.
+	Dir string
+	// The file name of the page, "types.html".
+	Base string
+	// The file name without its extension, "types".
+	Name string
+}
+
+func (o *OnlineURL) UnmarshalJSON(data []byte) error {
+	var raw interface{}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+	var d OnlineURL
+	switch v := raw.(type) {
+	case string:
+		d.Template = v
+	case map[string]interface{}:
+		r, err := decodeOnlineURLRule(v)
+		if err != nil {
+			return err
+		}
+		d.Rules = []OnlineURLRule{r}
+	case []interface{}:
+		for i, el := range v {
+			m, ok := el.(map[string]interface{})
+			if !ok {
+				return fmt.Errorf("onlineURL rule %d must be an object, not %s", i, jsonKind(el))
+			}
+			r, err := decodeOnlineURLRule(m)
+			if err != nil {
+				return fmt.Errorf("onlineURL rule %d: %s", i, err)
+			}
+			d.Rules = append(d.Rules, r)
+		}
+	default:
+		return fmt.Errorf("onlineURL must be a string, an object or an array, not %s", jsonKind(raw))
+	}
+	if err := d.compile(); err != nil {
+		return err
+	}
+	*o = d
+	return nil
+}
+
+func decodeOnlineURLRule(m map[string]interface{}) (OnlineURLRule, error) {
+	var r OnlineURLRule
+	for _, key := range sortedKeys(m) {
+		s, ok := m[key].(string)
+		switch {
+		case key != "regexp" && key != "url":
+			return r, fmt.Errorf("unknown key '%s' (expected regexp or url)", key)
+		case !ok:
+			return r, fmt.Errorf("%s must be a string, not %s", key, jsonKind(m[key]))
+		case key == "regexp":
+			r.Regexp = s
+		default:
+			r.URL = s
+		}
+	}
+	if r.Regexp == "" || r.URL == "" {
+		return r, fmt.Errorf("a rule needs both regexp and url")
+	}
+	return r, nil
+}
+
+// compile parses the template, or the regexps of the rules.
+func (o *OnlineURL) compile() error {
+	if o.Template != "" {
+		t, err := template.New("onlineURL").Parse(o.Template)
+		if err != nil {
+			return fmt.Errorf("invalid onlineURL template: %s", err)
+		}
+		o.tmpl = t
+		return nil
+	}
+	for i := range o.Rules {
+		re, err := regexp.Compile(o.Rules[i].Regexp)
+		if err != nil {
+			return fmt.Errorf("onlineURL: failed to compile regexp '%s': %s", o.Rules[i].Regexp, err)
+		}
+		o.Rules[i].re = re
+	}
+	return nil
+}
+
+func (o OnlineURL) MarshalJSON() ([]byte, error) {
+	if o.Template != "" {
+		return json.Marshal(o.Template)
+	}
+	return json.Marshal(o.Rules)
+}
+
+// URL returns the online URL of the page at path in the docset, or "" if
+// no rule matches it.
+func (o *OnlineURL) URL(p string) (string, error) {
+	var raw string
+	if o.tmpl != nil {
+		dir := path.Dir(p) + "/"
+		if dir == "./" {
+			dir = ""
+		}
+		base := path.Base(p)
+		page := OnlinePage{
+			Path: p,
+			Dir:  dir,
+			Base: base,
+			Name: strings.TrimSuffix(base, path.Ext(base)),
+		}
+		var buf bytes.Buffer
+		if err := o.tmpl.Execute(&buf, page); err != nil {
+			return "", err
+		}
+		raw = buf.String()
+	} else {
+		for _, r := range o.Rules {
+			if m := r.re.FindStringSubmatchIndex(p); m != nil {
+				raw = string(r.re.ExpandString(nil, r.URL, p, m))
+				break
+			}
+		}
+		if raw == "" {
+			return "", nil
+		}
+	}
+	u, err := url.Parse(raw)
+	if err != nil || u.Scheme == "" || u.Host == "" {
+		return "", fmt.Errorf("online URL '%s' of %s is not an absolute URL", raw, p)
+	}
+	return u.String(), nil
+}
+
+// onlineComment is how Dash finds the online URL of a page.
+const onlineComment = " Online page at "
+
+// commentSafe keeps a URL from ending the comment it is written into.
+var commentSafe = strings.NewReplacer("--", "%2D%2D", ">", "%3E")
+
+// setOnlineURL puts Dash's "Online page at" comment at the start of the
+// html element, replacing one that is already there.
+func setOnlineURL(root *html.Node, u string) {
+	top := root
+	for top != nil && !(top.Type == html.ElementNode && top.Data == "html") {
+		top = top.FirstChild
+		for top != nil && top.Type != html.ElementNode {
+			top = top.NextSibling
+		}
+	}
+	if top == nil {
+		return
+	}
+	data := onlineComment + commentSafe.Replace(u) + " "
+	for c := top.FirstChild; c != nil; c = c.NextSibling {
+		if c.Type == html.CommentNode && strings.HasPrefix(c.Data, onlineComment) {
+			c.Data = data
+			return
+		}
+	}
+	top.InsertBefore(&html.Node{Type: html.CommentNode, Data: data}, top.FirstChild)
+}
@@ -0,0 +1,70 @@
+package docset
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+	"testing/fstest"
+)
+
+func TestOnlineURL(t *testing.T) {
+	tests := []struct {
+		config, page, want string
+	}{
+		{`"https://docs.example.com/{{.Dir}}{{.Name}}/"`, "index.html", "https://docs.example.com/index/"},
+		{`"https://docs.example.com/{{.Dir}}{{.Name}}/"`, "api/types.html", "https://docs.example.com/api/types/"},
+		{`"https://docs.example.com/v2/{{.Path}}#{{.Base}}"`, "a/b/c.html", "https://docs.example.com/v2/a/b/c.html#c.html"},
+		{`{"regexp": "^api/(.+)\\.html$", "url": "https://d.example.com/ref/$1"}`, "api/x.html", "https://d.example.com/ref/x"},
+		// Only the URL of the rule is used, not the rest of the path.
+		{`{"regexp": "api/(.+)\\.html", "url": "https://d.example.com/ref/$1"}`, "v1/api/x.html", "https://d.example.com/ref/x"},
+		{`{"regexp": "api/(?P<name>.+)\\.html", "url": "https://d.example.com/ref/${name}.htm"}`, "api/x.html", "https://d.example.com/ref/x.htm"},
+		{`[{"regexp": "^api/", "url": "https://d.example.com/api"}, {"regexp": ".", "url": "https://d.example.com/other"}]`, "api/x.html", "https://d.example.com/api"},
+		{`[{"regexp": "^api/", "url": "https://d.example.com/api"}, {"regexp": ".", "url": "https://d.example.com/other"}]`, "x.html", "https://d.example.com/other"},
+		{`{"regexp": "^api/", "url": "https://d.example.com/api"}`, "guide/x.html", ""},
+	}
+	for _, tt := range tests {
+		var o OnlineURL
+		if err := json.Unmarshal([]byte(tt.config), &o); err != nil {
+			t.Errorf("%s: %s", tt.config, err)
+			continue
+		}
+		got, err := o.URL(tt.page)
+		if err != nil {
+			t.Errorf("%s: URL(%q): %s", tt.config, tt.page, err)
+		} else if got != tt.want {
+			t.Errorf("%s: URL(%q) = %q, want %q", tt.config, tt.page, got, tt.want)
+		}
+	}
+}
+
+func TestOnlineURLNotAbsolute(t *testing.T) {
+	src := fstest.MapFS{
+		"api/x.html":   {Data: []byte("<h1>X</h1>")},
+		"guide/y.html": {Data: []byte("<h1>Y</h1>")},
+	}
+	config := testConfig()
+	config.OnlineURL = &OnlineURL{}
+	if err := json.Unmarshal([]byte(`{"regexp": "^api/(.+)\\.html$", "url": "$1"}`), config.OnlineURL); err != nil {
+		t.Fatal(err)
+	}
+	b := testBuilder(t, config, src)
+	report, err := b.Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(report.Failed) != 0 || report.Entries != 2 {
+		t.Errorf("build failed %v, with %d entries", report.Failed, report.Entries)
+	}
+	if len(report.Warnings) != 1 || !strings.Contains(report.Warnings[0], "api/x.html") {
+		t.Errorf("warnings are %q, want one about api/x.html", report.Warnings)
+	}
+	data, err := ioutil.ReadFile(filepath.Join(b.Path(), "Contents", "Resources", "Documents", "api", "x.html"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(data), onlineComment) {
+		t.Errorf("api/x.html was marked with an online page:\n%s", data)
+	}
+}
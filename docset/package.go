@@ -0,0 +1,121 @@
+package docset
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Package writes a docset directory to dest as a gzipped tar archive, the
+// form Dash feeds serve docsets in, and returns the archive's SHA-256.
+//
+// The archive holds the docset directory itself, so that it unpacks to
+// <package>.docset. Files are added in lexical order, and every entry gets
+// modTime and neutral owners and permissions, so the same docset always
+// gives the same archive. Finder litter such as .DS_Store, and the
+// manifest that dashing update keeps, are left out.
+func Package(docset, dest string, modTime time.Time) (string, error) {
+	tmp, err := ioutil.TempFile(filepath.Dir(dest), ".dashing-package-")
+	if err != nil {
+		return "", err
+	}
+	defer os.Remove(tmp.Name())
+
+	h := sha256.New()
+	if err := writePackage(io.MultiWriter(tmp, h), docset, modTime); err != nil {
+		tmp.Close()
+		return "", err
+	}
+	if err := tmp.Close(); err != nil {
+		return "", err
+	}
+	if err := os.Rename(tmp.Name(), dest); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+func writePackage(w io.Writer, docset string, modTime time.Time) error {
+	gz, err := gzip.NewWriterLevel(w, gzip.BestCompression)
+	if err != nil {
+		return err
+	}
+	gz.ModTime = modTime
+	tw := tar.NewWriter(gz)
+
+	base := filepath.Base(filepath.Clean(docset))
+	err = filepath.Walk(docset, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if packageJunk(info.Name()) {
+			if info.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		rel, err := filepath.Rel(docset, path)
+		if err != nil {
+			return err
+		}
+		if filepath.ToSlash(rel) == manifestPath {
+			// Only of use to dashing update, on the machine that built it.
+			return nil
+		}
+		name := filepath.ToSlash(filepath.Join(base, rel))
+		hdr := &tar.Header{
+			Name:    name,
+			ModTime: modTime,
+			Mode:    0644,
+		}
+		switch {
+		case info.IsDir():
+			hdr.Typeflag = tar.TypeDir
+			hdr.Name += "/"
+			hdr.Mode = 0755
+			return tw.WriteHeader(hdr)
+		case info.Mode().IsRegular():
+			hdr.Typeflag = tar.TypeReg
+			hdr.Size = info.Size()
+		default:
+			// Nothing a docset needs.
+			return nil
+		}
+		if err := tw.WriteHeader(hdr); err != nil {
+			return err
+		}
+		f, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		_, err = io.Copy(tw, f)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	if err := tw.Close(); err != nil {
+		return err
+	}
+	return gz.Close()
+}
+
+// packageJunk reports whether a file is operating system litter that does
+// not belong in a package.
+func packageJunk(name string) bool {
+	switch name {
+	case ".DS_Store", "Thumbs.db", "desktop.ini":
+		return true
+	}
+	// AppleDouble files, which carry resource forks and extended
+	// attributes.
+	return strings.HasPrefix(name, "._")
+}
@@ -0,0 +1,178 @@
+package docset
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const plistHeader = `<?xml version="1.0" encoding="UTF-8"?>
+<!DOCTYPE plist PUBLIC "-//Apple//DTD PLIST 1.0//EN" "http://www.apple.com/DTDs/PropertyList-1.0.dtd">
+<plist version="1.0">
+`
+
+// plistEntry is a key and value of a plist dictionary.
+type plistEntry struct {
+	Key   string
+	Value interface{}
+}
+
+// addPlist writes the docset's Info.plist.
+func addPlist(docset, name string, config *Dashing) error {
+	data, err := encodePlist(plistEntries(name, config))
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(filepath.Join(docset, "Contents", "Info.plist"), data, 0755)
+}
+
+// plistEntries returns the keys of a docset's Info.plist, in order. Keys
+// in the configuration's Plist replace the generated ones, and the rest
+// are added at the end in sorted order.
+func plistEntries(name string, config *Dashing) []plistEntry {
+	fancyName := config.Name
+	if len(fancyName) == 0 {
+		fancyName = strings.ToTitle(name)
+	}
+	index := config.Index
+	if config.Markdown && markdownish(strings.SplitN(index, "#", 2)[0]) {
+		parts := strings.SplitN(index, "#", 2)
+		parts[0] = markdownPage(parts[0])
+		index = strings.Join(parts, "#")
+	}
+	dict := []plistEntry{
+		{"CFBundleIdentifier", name},
+		{"CFBundleName", fancyName},
+		{"DocSetPlatformFamily", name},
+		{"isDashDocset", true},
+		{"DashDocSetFamily", "dashtoc"},
+		{"dashIndexFilePath", index},
+		{"isJavaScriptEnabled", config.AllowJS},
+	}
+	add := func(key string, val interface{}, ok bool) {
+		if ok {
+			dict = append(dict, plistEntry{key, val})
+		}
+	}
+	add("DashDocSetFallbackURL", config.ExternalURL, config.ExternalURL != "")
+	add("DashDocSetKeyword", config.Keyword, config.Keyword != "")
+	add("DashDocSetPluginKeyword", config.PluginKeyword, config.PluginKeyword != "")
+	add("DashDocSetDefaultFTSEnabled", true, config.DefaultFTS)
+	add("DashDocSetFTSNotSupported", true, config.FTSNotSupported)
+	add("DashDocSetPlayURL", config.PlayURL, config.PlayURL != "")
+	add("DashDocSetBlocksOnlineResources", true, config.BlocksOnlineResources)
+
+	keys := make([]string, 0, len(config.Plist))
+	for k := range config.Plist {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		replaced := false
+		for i := range dict {
+			if dict[i].Key == k {
+				dict[i].Value = config.Plist[k]
+				replaced = true
+			}
+		}
+		add(k, config.Plist[k], !replaced)
+	}
+	return dict
+}
+
+// encodePlist encodes a property list whose root is a dictionary.
+func encodePlist(dict []plistEntry) ([]byte, error) {
+	var buf bytes.Buffer
+	buf.WriteString(plistHeader)
+	if err := writePlistValue(&buf, dict, 0); err != nil {
+		return nil, err
+	}
+	buf.WriteString("</plist>\n")
+	return buf.Bytes(), nil
+}
+
+// writePlistValue writes a value as plist XML, indented by depth tabs.
+// Strings, booleans, numbers, times, slices and maps of those can be
+// written.
+func writePlistValue(buf *bytes.Buffer, val interface{}, depth int) error {
+	indent := strings.Repeat("\t", depth)
+	switch v := val.(type) {
+	case string:
+		buf.WriteString(indent + "<string>")
+		xml.EscapeText(buf, []byte(v))
+		buf.WriteString("</string>\n")
+	case bool:
+		if v {
+			buf.WriteString(indent + "<true/>\n")
+		} else {
+			buf.WriteString(indent + "<false/>\n")
+		}
+	case int:
+		fmt.Fprintf(buf, "%s<integer>%d</integer>\n", indent, v)
+	case int64:
+		fmt.Fprintf(buf, "%s<integer>%d</integer>\n", indent, v)
+	case float64:
+		if math.IsNaN(v) || math.IsInf(v, 0) {
+			return fmt.Errorf("cannot store %v in a plist", v)
+		}
+		if v == math.Trunc(v) && math.Abs(v) < 1<<53 {
+			// JSON has no integers, so whole numbers are taken to be
+			// integers.
+			fmt.Fprintf(buf, "%s<integer>%d</integer>\n", indent, int64(v))
+		} else {
+			fmt.Fprintf(buf, "%s<real>%s</real>\n", indent, strconv.FormatFloat(v, 'g', -1, 64))
+		}
+	case time.Time:
+		fmt.Fprintf(buf, "%s<date>%s</date>\n", indent, v.UTC().Format(time.RFC3339))
+	case []interface{}:
+		if len(v) == 0 {
+			buf.WriteString(indent + "<array/>\n")
+			return nil
+		}
+		buf.WriteString(indent + "<array>\n")
+		for i, el := range v {
+			if err := writePlistValue(buf, el, depth+1); err != nil {
+				return fmt.Errorf("[%d]: %s", i, err)
+			}
+		}
+		buf.WriteString(indent + "</array>\n")
+	case map[string]interface{}:
+		keys := make([]string, 0, len(v))
+		for k := range v {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		dict := make([]plistEntry, len(keys))
+		for i, k := range keys {
+			dict[i] = plistEntry{k, v[k]}
+		}
+		return writePlistValue(buf, dict, depth)
+	case []plistEntry:
+		if len(v) == 0 {
+			buf.WriteString(indent + "<dict/>\n")
+			return nil
+		}
+		buf.WriteString(indent + "<dict>\n")
+		for _, e := range v {
+			buf.WriteString(indent + "\t<key>")
+			xml.EscapeText(buf, []byte(e.Key))
+			buf.WriteString("</key>\n")
+			if err := writePlistValue(buf, e.Value, depth+1); err != nil {
+				return fmt.Errorf("%s: %s", e.Key, err)
+			}
+		}
+		buf.WriteString(indent + "</dict>\n")
+	case nil:
+		return fmt.Errorf("cannot store null in a plist")
+	default:
+		return fmt.Errorf("cannot store %T in a plist", val)
+	}
+	return nil
+}
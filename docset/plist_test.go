@@ -0,0 +1,108 @@
+package docset
+
+import (
+	"math"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestEncodePlist(t *testing.T) {
+	dict := []plistEntry{
+		{"CFBundleName", "Tom & Jerry <3"},
+		{"isDashDocset", true},
+		{"isJavaScriptEnabled", false},
+		{"count", 3.0},
+		{"ratio", 1.5},
+		{"big", int64(1) << 40},
+		{"built", time.Date(2021, 3, 4, 5, 6, 7, 0, time.FixedZone("CET", 3600))},
+		{"list", []interface{}{"a", 2.0}},
+		{"empty", []interface{}{}},
+		{"nested", map[string]interface{}{"b": "2", "a": map[string]interface{}{}}},
+	}
+	got, err := encodePlist(dict)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := plistHeader + `<dict>
+	<key>CFBundleName</key>
+	<string>Tom &amp; Jerry &lt;3</string>
+	<key>isDashDocset</key>
+	<true/>
+	<key>isJavaScriptEnabled</key>
+	<false/>
+	<key>count</key>
+	<integer>3</integer>
+	<key>ratio</key>
+	<real>1.5</real>
+	<key>big</key>
+	<integer>1099511627776</integer>
+	<key>built</key>
+	<date>2021-03-04T04:06:07Z</date>
+	<key>list</key>
+	<array>
+		<string>a</string>
+		<integer>2</integer>
+	</array>
+	<key>empty</key>
+	<array/>
+	<key>nested</key>
+	<dict>
+		<key>a</key>
+		<dict/>
+		<key>b</key>
+		<string>2</string>
+	</dict>
+</dict>
+</plist>
+`
+	if string(got) != want {
+		t.Errorf("encodePlist gave\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestEncodePlistErrors(t *testing.T) {
+	tests := []struct {
+		value interface{}
+		err   string
+	}{
+		{nil, "key: cannot store null in a plist"},
+		{math.NaN(), "key: cannot store NaN in a plist"},
+		{[]interface{}{"a", nil}, "key: [1]: cannot store null in a plist"},
+		{map[string]interface{}{"inner": struct{}{}}, "key: inner: cannot store struct {} in a plist"},
+	}
+	for _, tt := range tests {
+		_, err := encodePlist([]plistEntry{{"key", tt.value}})
+		if err == nil || err.Error() != tt.err {
+			t.Errorf("encodePlist(%v): got error %v, want %q", tt.value, err, tt.err)
+		}
+	}
+}
+
+func TestPlistEntries(t *testing.T) {
+	config := &Dashing{
+		Index:    "guide/index.md#start",
+		Markdown: true,
+		Keyword:  "go",
+		Plist:    map[string]interface{}{"isJavaScriptEnabled": true, "DashDocSetFamily": "custom", "zzz": "last"},
+	}
+	var keys []string
+	values := map[string]interface{}{}
+	for _, e := range plistEntries("mydocs", config) {
+		keys = append(keys, e.Key)
+		values[e.Key] = e.Value
+	}
+	wantKeys := "CFBundleIdentifier CFBundleName DocSetPlatformFamily isDashDocset DashDocSetFamily dashIndexFilePath isJavaScriptEnabled DashDocSetKeyword zzz"
+	if strings.Join(keys, " ") != wantKeys {
+		t.Errorf("keys are %v, want %s", keys, wantKeys)
+	}
+	if values["CFBundleName"] != "MYDOCS" {
+		t.Errorf("CFBundleName = %v", values["CFBundleName"])
+	}
+	if values["dashIndexFilePath"] != "guide/index.html#start" {
+		t.Errorf("dashIndexFilePath = %v", values["dashIndexFilePath"])
+	}
+	if values["DashDocSetFamily"] != "custom" || values["isJavaScriptEnabled"] != true {
+		t.Errorf("plist settings did not replace the generated keys: %v", values)
+	}
+}
@@ -0,0 +1,137 @@
+package docset
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"sort"
+	"time"
+)
+
+// Report is a machine-readable summary of a build.
+type Report struct {
+	Package string `json:"package"`
+	Source  string `json:"source"`
+	// Number of HTML files parsed.
+	Processed int `json:"processed"`
+	// Number of other files copied into the docset.
+	Copied int `json:"copied"`
+	// Number of files skipped by an update because they had not changed.
+	Unchanged int `json:"unchanged"`
+	// Files removed from the docset by an update.
+	Removed []string `json:"removed"`
+	// Files that could not be processed.
+	Failed []reportFailure `json:"failed"`
+	// The errors behind Failed, which are *FileErrors.
+	Errors []error `json:"-"`
+	// Problems that did not stop a file from being processed.
+	Warnings []string `json:"warnings"`
+	// Number of entries in the docset, in total and by type.
+	Entries       int            `json:"entries"`
+	EntriesByType map[string]int `json:"entriesByType"`
+	// Matches that did not become entries.
+	Ignored []reportEntry `json:"ignored"`
+	// Entries with the same name and type in more than one place.
+	Duplicates []reportDuplicate `json:"duplicates"`
+	// Entries that could not be written to the index.
+	IndexFailures []reportEntry `json:"indexFailures"`
+	Timings       reportTimings `json:"timings"`
+}
+
+type reportFailure struct {
+	Path  string `json:"path"`
+	Error string `json:"error"`
+}
+
+type reportEntry struct {
+	Name string `json:"name"`
+	Type string `json:"type"`
+	Path string `json:"path"`
+	// Why the entry was ignored, or why it failed.
+	Reason string `json:"reason"`
+}
+
+type reportDuplicate struct {
+	Name  string   `json:"name"`
+	Type  string   `json:"type"`
+	Paths []string `json:"paths"`
+}
+
+// reportTimings are in seconds.
+type reportTimings struct {
+	// Walking the source tree.
+	Walk float64 `json:"walk"`
+	// Parsing and copying files, and writing the index.
+	Process float64 `json:"process"`
+	// The part of Process spent writing the index.
+	Index float64 `json:"index"`
+	Total float64 `json:"total"`
+}
+
+func newReport(name, source string) *Report {
+	return &Report{
+		Package:       name,
+		Source:        source,
+		Removed:       []string{},
+		Failed:        []reportFailure{},
+		Warnings:      []string{},
+		EntriesByType: map[string]int{},
+		Ignored:       []reportEntry{},
+		Duplicates:    []reportDuplicate{},
+		IndexFailures: []reportEntry{},
+	}
+}
+
+// fail records a file that could not be processed.
+func (r *Report) fail(path string, err error) {
+	r.Failed = append(r.Failed, reportFailure{path, err.Error()})
+	r.Errors = append(r.Errors, &FileError{path, err})
+}
+
+// ignore records matches that did not become entries.
+func (r *Report) ignore(path string, skipped []*match) {
+	for _, m := range skipped {
+		r.Ignored = append(r.Ignored, reportEntry{m.name, m.etype, path, m.skipped})
+	}
+}
+
+// finish fills in the totals from the manifest of the finished build.
+func (r *Report) finish(m *manifest, ix *indexer) {
+	places := map[[2]string][]string{}
+	for _, path := range m.paths() {
+		for _, e := range m.Files[path].Entries {
+			r.Entries++
+			r.EntriesByType[e.Type]++
+			key := [2]string{e.Name, e.Type}
+			places[key] = append(places[key], e.Path)
+		}
+	}
+	for key, paths := range places {
+		if len(paths) > 1 {
+			r.Duplicates = append(r.Duplicates, reportDuplicate{key[0], key[1], paths})
+		}
+	}
+	sort.Slice(r.Duplicates, func(i, j int) bool {
+		a, b := r.Duplicates[i], r.Duplicates[j]
+		if a.Type != b.Type {
+			return a.Type < b.Type
+		}
+		return a.Name < b.Name
+	})
+	for _, f := range ix.failures {
+		r.IndexFailures = append(r.IndexFailures, reportEntry{f.ref.name, f.ref.etype, f.ref.href, f.err.Error()})
+	}
+	r.Timings.Index = seconds(ix.elapsed)
+}
+
+// Write saves the report as JSON.
+func (r *Report) Write(path string) error {
+	data, err := json.MarshalIndent(r, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, append(data, '\n'), 0644)
+}
+
+func seconds(d time.Duration) float64 {
+	return float64(d.Round(time.Millisecond)) / float64(time.Second)
+}
@@ -0,0 +1,210 @@
+package docset
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"time"
+)
+
+// OpenSource opens the files to build a docset from: a directory, or a
+// .zip, .tar, .tar.gz or .tgz archive. Paths in the returned file system
+// are relative to the directory or the root of the archive.
+//
+// The returned function releases the source, and must be called once the
+// build is done.
+func OpenSource(name string) (fs.FS, func() error, error) {
+	info, err := os.Stat(name)
+	if err != nil {
+		return nil, nil, err
+	}
+	if info.IsDir() {
+		return os.DirFS(name), func() error { return nil }, nil
+	}
+
+	lower := strings.ToLower(name)
+	switch {
+	case strings.HasSuffix(lower, ".zip"):
+		r, err := zip.OpenReader(name)
+		if err != nil {
+			return nil, nil, err
+		}
+		return r, r.Close, nil
+	case strings.HasSuffix(lower, ".tar"):
+		fsys, err := readTar(name, false)
+		return fsys, func() error { return nil }, err
+	case strings.HasSuffix(lower, ".tar.gz"), strings.HasSuffix(lower, ".tgz"):
+		fsys, err := readTar(name, true)
+		return fsys, func() error { return nil }, err
+	}
+	return nil, nil, fmt.Errorf("%s is not a directory, .zip, .tar or .tar.gz archive", name)
+}
+
+// readTar reads a tar archive into memory.
+func readTar(name string, gzipped bool) (fs.FS, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var r io.Reader = f
+	if gzipped {
+		gz, err := gzip.NewReader(f)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %s", name, err)
+		}
+		defer gz.Close()
+		r = gz
+	}
+
+	t := newTarFS()
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return t, nil
+		} else if err != nil {
+			return nil, fmt.Errorf("%s: %s", name, err)
+		}
+		p := path.Clean(strings.TrimPrefix(hdr.Name, "/"))
+		if p == "." {
+			continue
+		}
+		if !fs.ValidPath(p) {
+			return nil, fmt.Errorf("%s: invalid path '%s' in archive", name, hdr.Name)
+		}
+		switch hdr.Typeflag {
+		case tar.TypeDir:
+			t.mkdir(p, hdr.ModTime)
+		case tar.TypeReg:
+			data, err := io.ReadAll(tr)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %s", name, err)
+			}
+			t.add(p, data, fs.FileMode(hdr.Mode).Perm(), hdr.ModTime)
+		default:
+			// Links and devices have no place in a docset.
+		}
+	}
+}
+
+// tarFS is a read-only file system held in memory.
+type tarFS struct {
+	files map[string]*tarEntry
+}
+
+// tarEntry is a file or directory in a tarFS.
+type tarEntry struct {
+	name    string
+	data    []byte
+	mode    fs.FileMode
+	modTime time.Time
+	// Entries of a directory, by name.
+	children map[string]*tarEntry
+}
+
+func newTarFS() *tarFS {
+	root := &tarEntry{name: ".", mode: fs.ModeDir | 0755, children: map[string]*tarEntry{}}
+	return &tarFS{files: map[string]*tarEntry{".": root}}
+}
+
+// mkdir creates a directory and any missing parents.
+func (t *tarFS) mkdir(name string, modTime time.Time) *tarEntry {
+	if d, ok := t.files[name]; ok {
+		return d
+	}
+	parent := t.mkdir(path.Dir(name), modTime)
+	d := &tarEntry{name: path.Base(name), mode: fs.ModeDir | 0755, modTime: modTime, children: map[string]*tarEntry{}}
+	parent.children[d.name] = d
+	t.files[name] = d
+	return d
+}
+
+// add creates a file, and any missing directories.
+func (t *tarFS) add(name string, data []byte, mode fs.FileMode, modTime time.Time) {
+	parent := t.mkdir(path.Dir(name), modTime)
+	f := &tarEntry{name: path.Base(name), data: data, mode: mode, modTime: modTime}
+	parent.children[f.name] = f
+	t.files[name] = f
+}
+
+func (t *tarFS) Open(name string) (fs.File, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrInvalid}
+	}
+	e, ok := t.files[name]
+	if !ok {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrNotExist}
+	}
+	if e.mode.IsDir() {
+		return &tarDir{tarEntry: e, entries: e.list()}, nil
+	}
+	return &tarFile{tarEntry: e, Reader: bytes.NewReader(e.data)}, nil
+}
+
+// list returns the entries of a directory, sorted by name.
+func (e *tarEntry) list() []fs.DirEntry {
+	entries := make([]fs.DirEntry, 0, len(e.children))
+	for _, c := range e.children {
+		entries = append(entries, c)
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Name() < entries[j].Name() })
+	return entries
+}
+
+// tarEntry is its own fs.FileInfo and fs.DirEntry.
+func (e *tarEntry) Name() string               { return e.name }
+func (e *tarEntry) Size() int64                { return int64(len(e.data)) }
+func (e *tarEntry) Mode() fs.FileMode          { return e.mode }
+func (e *tarEntry) ModTime() time.Time         { return e.modTime }
+func (e *tarEntry) IsDir() bool                { return e.mode.IsDir() }
+func (e *tarEntry) Sys() interface{}           { return nil }
+func (e *tarEntry) Stat() (fs.FileInfo, error) { return e, nil }
+func (e *tarEntry) Info() (fs.FileInfo, error) { return e, nil }
+func (e *tarEntry) Type() fs.FileMode          { return e.mode.Type() }
+
+// tarFile is an open file in a tarFS.
+type tarFile struct {
+	*tarEntry
+	*bytes.Reader
+}
+
+func (f *tarFile) Close() error { return nil }
+
+// tarDir is an open directory in a tarFS.
+type tarDir struct {
+	*tarEntry
+	entries []fs.DirEntry
+}
+
+func (d *tarDir) Read([]byte) (int, error) {
+	return 0, &fs.PathError{Op: "read", Path: d.name, Err: fs.ErrInvalid}
+}
+
+func (d *tarDir) Close() error { return nil }
+
+func (d *tarDir) ReadDir(n int) ([]fs.DirEntry, error) {
+	if n <= 0 {
+		list := d.entries
+		d.entries = nil
+		return list, nil
+	}
+	if len(d.entries) == 0 {
+		return nil, io.EOF
+	}
+	if n > len(d.entries) {
+		n = len(d.entries)
+	}
+	list := d.entries[:n]
+	d.entries = d.entries[n:]
+	return list, nil
+}
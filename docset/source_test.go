@@ -0,0 +1,135 @@
+package docset
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"testing"
+	"testing/fstest"
+	"time"
+)
+
+func TestTarFS(t *testing.T) {
+	modTime := time.Date(2021, 1, 2, 3, 4, 5, 0, time.UTC)
+	fsys := newTarFS()
+	fsys.add("index.html", []byte("<h1>Index</h1>"), 0644, modTime)
+	fsys.add("guide/intro.html", []byte("<h1>Intro</h1>"), 0644, modTime)
+	fsys.mkdir("guide/empty", modTime)
+	fsys.add("assets/css/style.css", []byte("body {}"), 0600, modTime)
+
+	if err := fstest.TestFS(fsys, "index.html", "guide/intro.html", "guide/empty", "assets/css/style.css"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := fsys.Open("../index.html"); err == nil {
+		t.Error("opened a path outside the file system")
+	}
+}
+
+// archiveFiles are the files written into the test archives. Directories
+// are only implied by the file names.
+var archiveFiles = []struct {
+	name, data string
+}{
+	{"./index.html", "<h1>Index</h1>"},
+	{"guide/intro.html", "<h1>Intro</h1>"},
+	{"/assets/style.css", "body {}"},
+}
+
+func TestOpenSourceTarGz(t *testing.T) {
+	name := filepath.Join(t.TempDir(), "docs.tar.gz")
+	f, err := os.Create(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	gz := gzip.NewWriter(f)
+	tw := tar.NewWriter(gz)
+	for _, a := range archiveFiles {
+		hdr := &tar.Header{Name: a.name, Mode: 0644, Size: int64(len(a.data)), Typeflag: tar.TypeReg}
+		if err := tw.WriteHeader(hdr); err != nil {
+			t.Fatal(err)
+		}
+		io.WriteString(tw, a.data)
+	}
+	// Links have no place in a docset and are left out.
+	tw.WriteHeader(&tar.Header{Name: "link.html", Linkname: "index.html", Typeflag: tar.TypeSymlink})
+	for _, c := range []io.Closer{tw, gz, f} {
+		if err := c.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	testSource(t, name)
+}
+
+func TestOpenSourceZip(t *testing.T) {
+	name := filepath.Join(t.TempDir(), "docs.zip")
+	f, err := os.Create(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	zw := zip.NewWriter(f)
+	for _, a := range archiveFiles {
+		w, err := zw.Create(path.Clean(strings.TrimPrefix(a.name, "/")))
+		if err != nil {
+			t.Fatal(err)
+		}
+		io.WriteString(w, a.data)
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := f.Close(); err != nil {
+		t.Fatal(err)
+	}
+	testSource(t, name)
+}
+
+// testSource checks that an archive made of archiveFiles opens as a file
+// system with those files in it.
+func testSource(t *testing.T, name string) {
+	t.Helper()
+	fsys, closeSource, err := OpenSource(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer closeSource()
+
+	var files []string
+	fs.WalkDir(fsys, ".", func(name string, d fs.DirEntry, err error) error {
+		if err != nil {
+			t.Errorf("%s: %s", name, err)
+		} else if !d.IsDir() {
+			files = append(files, name)
+		}
+		return nil
+	})
+	want := []string{"assets/style.css", "guide/intro.html", "index.html"}
+	if len(files) != len(want) {
+		t.Fatalf("files are %v, want %v", files, want)
+	}
+	for i := range want {
+		if files[i] != want[i] {
+			t.Errorf("files are %v, want %v", files, want)
+			break
+		}
+	}
+	data, err := fs.ReadFile(fsys, "guide/intro.html")
+	if err != nil || string(data) != "<h1>Intro</h1>" {
+		t.Errorf("guide/intro.html is %q, %v", data, err)
+	}
+}
+
+func TestOpenSourceUnknown(t *testing.T) {
+	name := filepath.Join(t.TempDir(), "docs.rar")
+	if err := os.WriteFile(name, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := OpenSource(name); err == nil {
+		t.Error("opened an archive of an unknown format")
+	}
+}
@@ -0,0 +1,187 @@
+package docset
+
+import (
+	"fmt"
+	"strings"
+)
+
+// entryTypes are the entry types that Dash supports.
+//
+// See https://kapeli.com/docsets#supportedentrytypes
+var entryTypes = map[string]bool{
+	"Annotation":  true,
+	"Attribute":   true,
+	"Binding":     true,
+	"Builtin":     true,
+	"Callback":    true,
+	"Category":    true,
+	"Class":       true,
+	"Command":     true,
+	"Component":   true,
+	"Constant":    true,
+	"Constructor": true,
+	"Define":      true,
+	"Delegate":    true,
+	"Diagram":     true,
+	"Directive":   true,
+	"Element":     true,
+	"Entry":       true,
+	"Enum":        true,
+	"Environment": true,
+	"Error":       true,
+	"Event":       true,
+	"Exception":   true,
+	"Extension":   true,
+	"Field":       true,
+	"File":        true,
+	"Filter":      true,
+	"Framework":   true,
+	"Function":    true,
+	"Global":      true,
+	"Guide":       true,
+	"Hook":        true,
+	"Instance":    true,
+	"Instruction": true,
+	"Interface":   true,
+	"Keyword":     true,
+	"Library":     true,
+	"Literal":     true,
+	"Macro":       true,
+	"Method":      true,
+	"Mixin":       true,
+	"Modifier":    true,
+	"Module":      true,
+	"Namespace":   true,
+	"Notation":    true,
+	"Object":      true,
+	"Operator":    true,
+	"Option":      true,
+	"Package":     true,
+	"Parameter":   true,
+	"Plugin":      true,
+	"Procedure":   true,
+	"Property":    true,
+	"Protocol":    true,
+	"Provider":    true,
+	"Provisioner": true,
+	"Query":       true,
+	"Record":      true,
+	"Resource":    true,
+	"Sample":      true,
+	"Section":     true,
+	"Service":     true,
+	"Setting":     true,
+	"Shortcut":    true,
+	"Statement":   true,
+	"Struct":      true,
+	"Style":       true,
+	"Subroutine":  true,
+	"Tag":         true,
+	"Test":        true,
+	"Trait":       true,
+	"Type":        true,
+	"Union":       true,
+	"Value":       true,
+	"Variable":    true,
+	"Word":        true,
+}
+
+// entryTypeAliases maps common abbreviations to the entry type Dash uses.
+var entryTypeAliases = map[string]string{
+	"cmd":         "Command",
+	"const":       "Constant",
+	"ctor":        "Constructor",
+	"enumeration": "Enum",
+	"env":         "Environment",
+	"fn":          "Function",
+	"func":        "Function",
+	"iface":       "Interface",
+	"kw":          "Keyword",
+	"mod":         "Module",
+	"ns":          "Namespace",
+	"op":          "Operator",
+	"param":       "Parameter",
+	"pkg":         "Package",
+	"prop":        "Property",
+	"struct":      "Struct",
+	"structure":   "Struct",
+	"typedef":     "Type",
+	"var":         "Variable",
+}
+
+// normalizeEntryType returns the spelling Dash uses for an entry type.
+//
+// Known types are matched regardless of case, and common abbreviations are
+// expanded. Types listed in custom are accepted exactly as written. Any
+// other type is an error.
+func normalizeEntryType(t string, custom []string) (string, error) {
+	for _, c := range custom {
+		if t == c {
+			return t, nil
+		}
+	}
+	if entryTypes[t] {
+		return t, nil
+	}
+	lower := strings.ToLower(t)
+	for known := range entryTypes {
+		if strings.ToLower(known) == lower {
+			return known, nil
+		}
+	}
+	if alias, ok := entryTypeAliases[lower]; ok {
+		return alias, nil
+	}
+	if t == "" {
+		return "", fmt.Errorf("missing entry type")
+	}
+	if s := suggestEntryType(t); s != "" {
+		return "", fmt.Errorf("unknown entry type '%s' (did you mean '%s'? Add it to customTypes to use it anyway)", t, s)
+	}
+	return "", fmt.Errorf("unknown entry type '%s' (add it to customTypes to use it anyway)", t)
+}
+
+// suggestEntryType returns the known entry type closest to t, if there is
+// one within a couple of typos.
+func suggestEntryType(t string) string {
+	best, bestDist := "", 3
+	for known := range entryTypes {
+		d := editDistance(strings.ToLower(t), strings.ToLower(known))
+		if d < bestDist || (d == bestDist && best != "" && known < best) {
+			best, bestDist = known, d
+		}
+	}
+	return best
+}
+
+// editDistance is the Levenshtein distance between a and b.
+func editDistance(a, b string) int {
+	ra, rb := []rune(a), []rune(b)
+	prev := make([]int, len(rb)+1)
+	cur := make([]int, len(rb)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(ra); i++ {
+		cur[0] = i
+		for j := 1; j <= len(rb); j++ {
+			cost := 1
+			if ra[i-1] == rb[j-1] {
+				cost = 0
+			}
+			cur[j] = min3(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+		}
+		prev, cur = cur, prev
+	}
+	return prev[len(rb)]
+}
+
+func min3(a, b, c int) int {
+	if b < a {
+		a = b
+	}
+	if c < a {
+		a = c
+	}
+	return a
+}
@@ -0,0 +1,41 @@
+package docset
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestNormalizeEntryType(t *testing.T) {
+	custom := []string{"Widget", "cmd"}
+	tests := []struct {
+		in, want string
+		err      string
+	}{
+		{in: "Command", want: "Command"},
+		{in: "command", want: "Command"},
+		{in: "COMMAND", want: "Command"},
+		{in: "fn", want: "Function"},
+		{in: "Struct", want: "Struct"},
+		{in: "structure", want: "Struct"},
+		{in: "Widget", want: "Widget"},
+		// Custom types win over aliases, and are case-sensitive.
+		{in: "cmd", want: "cmd"},
+		{in: "widget", err: "unknown entry type 'widget'"},
+		{in: "Commnad", err: "unknown entry type 'Commnad' (did you mean 'Command'?"},
+		{in: "Xyzzyplugh", err: "unknown entry type 'Xyzzyplugh' (add it to customTypes"},
+		{in: "", err: "missing entry type"},
+	}
+	for _, tt := range tests {
+		got, err := normalizeEntryType(tt.in, custom)
+		switch {
+		case tt.err != "":
+			if err == nil || !strings.HasPrefix(err.Error(), tt.err) {
+				t.Errorf("normalizeEntryType(%q): got %q, %v, want an error starting with %q", tt.in, got, err, tt.err)
+			}
+		case err != nil:
+			t.Errorf("normalizeEntryType(%q): %s", tt.in, err)
+		case got != tt.want:
+			t.Errorf("normalizeEntryType(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,373 @@
+package docset
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net/url"
+	"os"
+	"path"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	css "github.com/andybalholm/cascadia"
+)
+
+// Problem is a mistake found in a configuration file.
+type Problem struct {
+	// JSON path of the offending value, e.g. $.selectors["dt a"].type
+	Path string
+	Msg  string
+}
+
+func (p Problem) String() string {
+	return p.Path + ": " + p.Msg
+}
+
+// validator collects the problems found in a configuration.
+type validator struct {
+	// The source files.
+	source fs.FS
+	// Entry types the configuration allows in addition to Dash's.
+	custom   []string
+	problems []Problem
+}
+
+func (v *validator) add(path, format string, args ...interface{}) {
+	for _, p := range v.problems {
+		if p.Path == path && strings.HasPrefix(path, "$.") && !strings.ContainsAny(path[2:], ".[") {
+			// Only report one problem per top-level field.
+			return
+		}
+	}
+	v.problems = append(v.problems, Problem{path, fmt.Sprintf(format, args...)})
+}
+
+// Validate strictly checks a JSON configuration, as returned by
+// ReadConfig, and returns every problem found, with the keys of each object
+// taken in sorted order. source holds the files the docset will be built
+// from.
+func Validate(data []byte, source fs.FS) []Problem {
+	v := &validator{source: source}
+
+	var raw interface{}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		if se, ok := err.(*json.SyntaxError); ok {
+			line := 1 + strings.Count(string(data[:se.Offset]), "\n")
+			v.add("$", "invalid JSON on line %d: %s", line, err)
+		} else {
+			v.add("$", "invalid JSON: %s", err)
+		}
+		return v.problems
+	}
+	obj, ok := raw.(map[string]interface{})
+	if !ok {
+		v.add("$", "expected an object, got %s", jsonKind(raw))
+		return v.problems
+	}
+
+	if custom, ok := obj["customTypes"].([]interface{}); ok {
+		for _, c := range custom {
+			if s, ok := c.(string); ok {
+				v.custom = append(v.custom, s)
+			}
+		}
+	}
+
+	fields := configFields()
+	for _, key := range sortedKeys(obj) {
+		path := jsonPath("$", key)
+		t, ok := fields[key]
+		if !ok {
+			v.unknownKey(path, key, fields)
+			continue
+		}
+		if key == "selectors" {
+			v.selectors(path, obj[key])
+			continue
+		}
+		v.decodes(path, obj[key], t)
+	}
+
+	// Fields with the wrong type are left empty, and settings does not
+	// report fields that already have a problem.
+	var d Dashing
+	json.Unmarshal(data, &d)
+	v.settings(d)
+	return v.problems
+}
+
+// settings checks the values of the top-level fields.
+func (v *validator) settings(d Dashing) {
+	if d.Package == "" {
+		v.add("$.package", "is required")
+	} else if strings.ContainsAny(d.Package, `/\`) {
+		v.add("$.package", "must not contain a path separator")
+	}
+	if d.Index != "" {
+		index := strings.SplitN(d.Index, "#", 2)[0]
+		if _, err := fs.Stat(v.source, path.Clean(index)); err != nil && !(d.Markdown && v.hasMarkdown(index)) {
+			v.add("$.index", "file %s not found in the source", index)
+		}
+	}
+	if d.Icon32x32 != "" {
+		if _, err := os.Stat(d.Icon32x32); err != nil {
+			v.add("$.icon32x32", "file %s not found", d.Icon32x32)
+		}
+	}
+	if d.Icon != nil {
+		v.icon("$.icon", d.Icon)
+		if d.Icon32x32 != "" {
+			v.add("$.icon32x32", "cannot be used together with icon")
+		}
+	}
+	if d.ExternalURL != "" {
+		if u, err := url.Parse(d.ExternalURL); err != nil || u.Scheme == "" || u.Host == "" {
+			v.add("$.externalURL", "'%s' is not an absolute URL", d.ExternalURL)
+		}
+	}
+	if d.SiteURL != "" {
+		if _, err := parseBaseURL(d.SiteURL); err != nil {
+			v.add("$.siteURL", "'%s' is not an absolute URL", d.SiteURL)
+		}
+	}
+	if d.PlayURL != "" {
+		if u, err := url.Parse(d.PlayURL); err != nil || u.Scheme == "" || u.Host == "" {
+			v.add("$.playURL", "'%s' is not an absolute URL", d.PlayURL)
+		}
+	}
+	if d.DefaultFTS && d.FTSNotSupported {
+		v.add("$.defaultFTS", "cannot be used together with ftsNotSupported")
+	}
+	for _, key := range sortedKeys(d.Plist) {
+		var buf bytes.Buffer
+		if err := writePlistValue(&buf, d.Plist[key], 0); err != nil {
+			v.add(jsonPath("$.plist", key), "%s", err)
+		}
+	}
+	if d.OnlineURL != nil && d.Index != "" {
+		// Try the mapping on a page that is sure to exist.
+		if _, err := d.OnlineURL.URL(path.Clean(strings.SplitN(d.Index, "#", 2)[0])); err != nil {
+			v.add("$.onlineURL", "%s", err)
+		}
+	}
+	if d.PageTemplate != "" {
+		if !d.Markdown {
+			v.add("$.pageTemplate", "is only used when markdown is true")
+		} else if _, err := newMarkdownRenderer(d); os.IsNotExist(err) {
+			v.add("$.pageTemplate", "file %s not found", d.PageTemplate)
+		} else if err != nil {
+			v.add("$.pageTemplate", "%s", err)
+		}
+	}
+	if d.Stylesheet != "" {
+		if !d.Markdown {
+			v.add("$.stylesheet", "is only used when markdown is true")
+		} else if _, err := os.Stat(d.Stylesheet); err != nil {
+			v.add("$.stylesheet", "file %s not found", d.Stylesheet)
+		}
+	}
+	for i, u := range d.FeedURLs {
+		if p, err := url.Parse(u); err != nil || p.Scheme == "" || p.Host == "" {
+			v.add(fmt.Sprintf("$.feedURLs[%d]", i), "'%s' is not an absolute URL", u)
+		}
+	}
+	switch d.ExternalLinks {
+	case "", externalKeep:
+	case externalOnline:
+		if d.ExternalURL == "" && d.SiteURL == "" {
+			v.add("$.externalLinks", "'%s' requires externalURL or siteURL", externalOnline)
+		}
+	default:
+		v.add("$.externalLinks", "unknown value '%s' (expected '%s' or '%s')", d.ExternalLinks, externalKeep, externalOnline)
+	}
+}
+
+// hasMarkdown reports whether the source has a Markdown file that becomes
+// the page at p.
+func (v *validator) hasMarkdown(p string) bool {
+	base := strings.TrimSuffix(path.Clean(p), path.Ext(p))
+	for _, ext := range []string{".md", ".markdown", ".mdown"} {
+		if _, err := fs.Stat(v.source, base+ext); err == nil {
+			return true
+		}
+	}
+	return false
+}
+
+// icon checks that the icon files exist and can be used.
+func (v *validator) icon(path string, icon *Icon) {
+	check := func(path, src string, size int) {
+		if src == "" {
+			return
+		}
+		if err := checkIcon(src, size); errors.Is(err, fs.ErrNotExist) {
+			v.add(path, "file %s not found", src)
+		} else if err != nil {
+			v.add(path, "%s: %s", src, err)
+		}
+	}
+	check(path, icon.Source, 0)
+	check(jsonPath(path, "1x"), icon.X1, iconSize)
+	check(jsonPath(path, "2x"), icon.X2, iconSize2x)
+}
+
+// selectors checks the selectors map.
+func (v *validator) selectors(path string, val interface{}) {
+	sels, ok := val.(map[string]interface{})
+	if !ok {
+		v.add(path, "expected an object, got %s", jsonKind(val))
+		return
+	}
+	for _, sel := range sortedKeys(sels) {
+		spath := fmt.Sprintf("%s[%s]", path, strconv.Quote(sel))
+		if _, err := css.Compile(sel); err != nil {
+			v.add(spath, "invalid CSS selector: %s", err)
+		}
+		switch t := sels[sel].(type) {
+		case string:
+			v.entryType(spath, t)
+		case map[string]interface{}:
+			v.transform(spath, t)
+		case []interface{}:
+			for i, el := range t {
+				epath := fmt.Sprintf("%s[%d]", spath, i)
+				if m, ok := el.(map[string]interface{}); ok {
+					v.transform(epath, m)
+				} else {
+					v.add(epath, "expected an object, got %s", jsonKind(el))
+				}
+			}
+		default:
+			v.add(spath, "expected a string, object or array, got %s", jsonKind(t))
+		}
+	}
+}
+
+// transform checks the map form of a selector.
+func (v *validator) transform(path string, t map[string]interface{}) {
+	known := make(map[string]reflect.Type, len(transformKeys))
+	for _, k := range transformKeys {
+		known[k] = reflect.TypeOf("")
+	}
+	if _, ok := t["type"]; !ok {
+		v.entryType(jsonPath(path, "type"), "")
+	}
+	for _, key := range sortedKeys(t) {
+		kpath := jsonPath(path, key)
+		if _, ok := known[key]; !ok {
+			v.unknownKey(kpath, key, known)
+			continue
+		}
+		s, ok := t[key].(string)
+		if !ok {
+			v.add(kpath, "expected a string, got %s", jsonKind(t[key]))
+			continue
+		}
+		switch key {
+		case "type":
+			v.entryType(kpath, s)
+		case "regexp", "requiretext", "matchpath":
+			if _, err := regexp.Compile(s); err != nil {
+				v.add(kpath, "invalid regexp: %s", err)
+			}
+		}
+	}
+}
+
+// entryType checks that Dash knows an entry type.
+func (v *validator) entryType(path, t string) {
+	if _, err := normalizeEntryType(t, v.custom); err != nil {
+		v.add(path, "%s", err)
+	}
+}
+
+// unknownKey reports a key that is not understood, suggesting a known key
+// that differs only in case.
+func (v *validator) unknownKey(path, key string, known map[string]reflect.Type) {
+	for k := range known {
+		if strings.EqualFold(k, key) {
+			v.add(path, "unknown key '%s' (did you mean '%s'?)", key, k)
+			return
+		}
+	}
+	v.add(path, "unknown key '%s'", key)
+}
+
+// decodes checks that val can be decoded into a value of type t.
+func (v *validator) decodes(path string, val interface{}, t reflect.Type) {
+	data, err := json.Marshal(val)
+	if err != nil {
+		v.add(path, "%s", err)
+		return
+	}
+	if err := json.Unmarshal(data, reflect.New(t).Interface()); err != nil {
+		if te, ok := err.(*json.UnmarshalTypeError); ok {
+			for _, f := range strings.Split(te.Field, ".") {
+				if _, err := strconv.Atoi(f); err == nil {
+					path = fmt.Sprintf("%s[%s]", path, f)
+				} else if f != "" {
+					path = jsonPath(path, f)
+				}
+			}
+			v.add(path, "expected %s, got %s", describeType(te.Type), te.Value)
+			return
+		}
+		v.add(path, "%s", err)
+	}
+}
+
+// configFields maps the JSON keys of the configuration to their types.
+func configFields() map[string]reflect.Type {
+	fields := map[string]reflect.Type{}
+	t := reflect.TypeOf(Dashing{})
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		name := strings.Split(f.Tag.Get("json"), ",")[0]
+		if name == "" || name == "-" {
+			continue
+		}
+		fields[name] = f.Type
+	}
+	return fields
+}
+
+func describeType(t reflect.Type) string {
+	switch t.Kind() {
+	case reflect.String:
+		return "a string"
+	case reflect.Bool:
+		return "a boolean"
+	case reflect.Int, reflect.Int64, reflect.Float64:
+		return "a number"
+	case reflect.Slice:
+		return "an array"
+	case reflect.Map, reflect.Struct:
+		return "an object"
+	}
+	return t.String()
+}
+
+var identifier = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// jsonPath appends key to a JSON path.
+func jsonPath(path, key string) string {
+	if identifier.MatchString(key) {
+		return path + "." + key
+	}
+	return fmt.Sprintf("%s[%s]", path, strconv.Quote(key))
+}
+
+func sortedKeys(m map[string]interface{}) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"errors"
+
+	"github.com/technosophos/dashing/docset"
+	"github.com/urfave/cli/v2"
+)
+
+// Exit codes, so that scripts can tell what kind of failure stopped a
+// command.
+const (
+	// Anything not covered below, such as failed expectations.
+	exitFailure = 1
+	// The configuration is missing or invalid.
+	exitConfig = 2
+	// Source files could not be read.
+	exitInput = 3
+	// The docset could not be written.
+	exitOutput = 4
+	// The build had warnings, and --fail-on-warning was given.
+	exitWarning = 5
+)
+
+// exitCode returns the exit code for an error: exitOutput for output
+// errors, exitInput for input errors and exitFailure for anything else.
+func exitCode(err error) int {
+	var out *docset.OutputError
+	var in *docset.InputError
+	switch {
+	case errors.As(err, &out):
+		return exitOutput
+	case errors.As(err, &in):
+		return exitInput
+	}
+	return exitFailure
+}
+
+// fatal logs a message and returns an error that makes the command exit
+// with code.
+func fatal(log *logger, code int, format string, args ...interface{}) error {
+	log.Errorf(format, args...)
+	return cli.Exit("", code)
+}
+
+// errorPolicy decides whether the errors and warnings collected during a
+// build make the command fail.
+type errorPolicy struct {
+	failOnError   bool
+	failOnWarning bool
+}
+
+// result logs every error and warning from the build, and returns the
+// error the command should exit with, if any.
+//
+// If there were output errors, the exit code is exitOutput; if there were
+// only input errors, it is exitInput. Warnings alone give exitWarning.
+func (p errorPolicy) result(log *logger, errs []error, warnings []string) error {
+	if len(errs) == 0 && len(warnings) == 0 {
+		return nil
+	}
+	log.Warnf("Build finished with %d error(s) and %d warning(s)", len(errs), len(warnings))
+	code := 0
+	for _, err := range errs {
+		log.Errorf("%s", err)
+		if c := exitCode(err); c > code {
+			code = c
+		}
+	}
+	for _, w := range warnings {
+		log.Warnf("%s", w)
+	}
+	if len(errs) > 0 && (p.failOnError || p.failOnWarning) {
+		return cli.Exit("", code)
+	}
+	if len(warnings) > 0 && p.failOnWarning {
+		return cli.Exit("", exitWarning)
+	}
+	return nil
+}
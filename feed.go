@@ -0,0 +1,57 @@
+package main
+
+import (
+	"os"
+
+	"github.com/technosophos/dashing/docset"
+	"github.com/urfave/cli/v2"
+)
+
+// feed writes the feed of a packaged docset, or moves an existing feed on
+// to a new version.
+func feed(c *cli.Context) error {
+	log, err := loggerFromFlags(c)
+	if err != nil {
+		return err
+	}
+	dashing, err := loadDashing(c, log)
+	if err != nil {
+		return err
+	}
+	b, err := docset.NewBuilder(dashing)
+	if err != nil {
+		return fatal(log, exitConfig, "%s", err)
+	}
+	if c.IsSet("output") {
+		b.Output = c.String("output")
+	}
+
+	version := dashing.Version
+	if c.IsSet("version") {
+		version = c.String("version")
+	}
+	if version == "" {
+		return fatal(log, exitConfig, "No version given: set `version` in the configuration, or pass --version")
+	}
+	urls := dashing.FeedURLs
+	if c.IsSet("url") {
+		urls = c.StringSlice("url")
+	}
+	if len(urls) == 0 {
+		return fatal(log, exitConfig, "No download URL given: set `feedURLs` in the configuration, or pass --url")
+	}
+	if _, err := os.Stat(b.PackagePath()); err != nil {
+		return fatal(log, exitInput, "Cannot write a feed for %s: %s (Run `dashing package`?)", b.PackagePath(), err)
+	}
+
+	file := b.FeedPath()
+	if c.IsSet("file") {
+		file = c.String("file")
+	}
+	f, err := docset.UpdateFeed(file, version, urls)
+	if err != nil {
+		return fatal(log, exitCode(err), "Failed to write feed %s: %s", file, err)
+	}
+	log.Infof("Wrote feed %s for %s version %s", file, dashing.Package, f.Version)
+	return nil
+}
@@ -1,10 +1,16 @@
 module github.com/technosophos/dashing
 
-go 1.13
+go 1.16
 
 require (
+	github.com/BurntSushi/toml v1.2.1
 	github.com/andybalholm/cascadia v1.1.1-0.20191115165331-903109d295d5
 	github.com/mattn/go-sqlite3 v2.0.1+incompatible
+	github.com/russross/blackfriday/v2 v2.1.0
+	github.com/srwiley/oksvg v0.0.0-20221011165216-be6e8873101c
+	github.com/srwiley/rasterx v0.0.0-20220730225603-2ab79fcdd4ef
 	github.com/urfave/cli/v2 v2.0.0
-	golang.org/x/net v0.0.0-20191207000613-e7e4b65ae663
+	golang.org/x/image v0.0.0-20211028202545-6944b10bf410
+	golang.org/x/net v0.0.0-20211118161319-6a13c67c3ce4
+	gopkg.in/yaml.v3 v3.0.1
 )
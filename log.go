@@ -0,0 +1,180 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/urfave/cli/v2"
+)
+
+// logLevel is the severity of a log message.
+type logLevel int
+
+const (
+	levelError logLevel = iota
+	levelWarn
+	levelInfo
+	levelDebug
+)
+
+func (l logLevel) String() string {
+	switch l {
+	case levelError:
+		return "error"
+	case levelWarn:
+		return "warn"
+	case levelInfo:
+		return "info"
+	}
+	return "debug"
+}
+
+// logger writes leveled log messages, either as plain text or as one JSON
+// object per line. It is safe for concurrent use.
+//
+// On an interactive terminal, it can also show a progress indicator, which
+// is cleared whenever a message is written.
+type logger struct {
+	mu    sync.Mutex
+	out   io.Writer
+	level logLevel
+	json  bool
+
+	// Where progress is drawn, or nil for no progress indicator.
+	tty          io.Writer
+	progressLine bool
+	lastProgress time.Time
+}
+
+func newLogger(out io.Writer, level logLevel, jsonFormat bool) *logger {
+	return &logger{out: out, level: level, json: jsonFormat}
+}
+
+// logFlags are the flags that control logging.
+func logFlags() []cli.Flag {
+	return []cli.Flag{
+		&cli.BoolFlag{
+			Name:    "quiet",
+			Aliases: []string{"q"},
+			Usage:   "Only log warnings and errors.",
+		},
+		&cli.BoolFlag{
+			Name:    "verbose",
+			Aliases: []string{"v"},
+			Usage:   "Log every file and entry.",
+		},
+		&cli.StringFlag{
+			Name:  "log-format",
+			Usage: "The log format: text or json.",
+			Value: "text",
+		},
+	}
+}
+
+// loggerFromFlags creates the logger selected by logFlags.
+func loggerFromFlags(c *cli.Context) (*logger, error) {
+	level := levelInfo
+	if c.Bool("quiet") {
+		level = levelWarn
+	}
+	if c.Bool("verbose") {
+		level = levelDebug
+	}
+	var jsonFormat bool
+	switch format := strings.ToLower(c.String("log-format")); format {
+	case "", "text":
+	case "json":
+		jsonFormat = true
+	default:
+		return nil, cli.Exit(fmt.Sprintf("Unknown log format '%s' (expected text or json)", format), exitFailure)
+	}
+	l := newLogger(os.Stdout, level, jsonFormat)
+	if !jsonFormat && level != levelDebug && isTerminal(os.Stderr) {
+		l.tty = os.Stderr
+	}
+	return l, nil
+}
+
+// isTerminal reports whether f is an interactive terminal.
+func isTerminal(f *os.File) bool {
+	info, err := f.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
+
+func (l *logger) Errorf(format string, args ...interface{}) {
+	l.logf(levelError, format, args...)
+}
+
+func (l *logger) Warnf(format string, args ...interface{}) {
+	l.logf(levelWarn, format, args...)
+}
+
+func (l *logger) Infof(format string, args ...interface{}) {
+	l.logf(levelInfo, format, args...)
+}
+
+func (l *logger) Debugf(format string, args ...interface{}) {
+	l.logf(levelDebug, format, args...)
+}
+
+func (l *logger) logf(level logLevel, format string, args ...interface{}) {
+	if level > l.level {
+		return
+	}
+	msg := fmt.Sprintf(format, args...)
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.clearProgress()
+	if l.json {
+		line, _ := json.Marshal(struct {
+			Time  string `json:"time"`
+			Level string `json:"level"`
+			Msg   string `json:"msg"`
+		}{time.Now().UTC().Format(time.RFC3339), level.String(), msg})
+		fmt.Fprintf(l.out, "%s\n", line)
+		return
+	}
+	if level <= levelWarn {
+		msg = level.String() + ": " + msg
+	}
+	fmt.Fprintln(l.out, msg)
+}
+
+// Progress shows how many of total files are done. Updates are limited to
+// a few per second.
+func (l *logger) Progress(done, total int, path string) {
+	if l.tty == nil {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if done < total && time.Since(l.lastProgress) < 100*time.Millisecond {
+		return
+	}
+	l.lastProgress = time.Now()
+	if len(path) > 50 {
+		path = "..." + path[len(path)-47:]
+	}
+	fmt.Fprintf(l.tty, "\r\033[K[%d/%d] %s", done, total, path)
+	l.progressLine = true
+}
+
+// EndProgress removes the progress indicator.
+func (l *logger) EndProgress() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.clearProgress()
+}
+
+func (l *logger) clearProgress() {
+	if l.progressLine {
+		fmt.Fprint(l.tty, "\r\033[K")
+		l.progressLine = false
+	}
+}
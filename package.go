@@ -0,0 +1,68 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/technosophos/dashing/docset"
+	"github.com/urfave/cli/v2"
+)
+
+// packageDocset packages a docset that has already been built.
+func packageDocset(c *cli.Context) error {
+	log, err := loggerFromFlags(c)
+	if err != nil {
+		return err
+	}
+	dashing, err := loadDashing(c, log)
+	if err != nil {
+		return err
+	}
+	b, err := docset.NewBuilder(dashing)
+	if err != nil {
+		return fatal(log, exitConfig, "%s", err)
+	}
+	if c.IsSet("output") {
+		b.Output = c.String("output")
+	}
+	if _, err := os.Stat(b.Path()); err != nil {
+		return fatal(log, exitInput, "Cannot package %s: %s (Run `dashing build`?)", b.Path(), err)
+	}
+	return writePackage(c, log, b)
+}
+
+// writePackage writes the package of the builder's docset, and prints its
+// SHA-256 if --sha was given.
+func writePackage(c *cli.Context, log *logger, b *docset.Builder) error {
+	modTime, err := packageTime()
+	if err != nil {
+		return fatal(log, exitFailure, "%s", err)
+	}
+	dest := b.PackagePath()
+	sum, err := docset.Package(b.Path(), dest, modTime)
+	if err != nil {
+		return fatal(log, exitOutput, "Failed to package %s: %s", b.Path(), err)
+	}
+	log.Infof("Packaged %s as %s", b.Path(), dest)
+	if c.Bool("sha") {
+		fmt.Printf("%s  %s\n", sum, dest)
+	}
+	return nil
+}
+
+// packageTime is the timestamp given to every file in a package. It is
+// taken from SOURCE_DATE_EPOCH if that is set, so that packages can match
+// the release they were built for; otherwise it is the Unix epoch.
+func packageTime() (time.Time, error) {
+	epoch := os.Getenv("SOURCE_DATE_EPOCH")
+	if epoch == "" {
+		return time.Unix(0, 0), nil
+	}
+	secs, err := strconv.ParseInt(epoch, 10, 64)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid SOURCE_DATE_EPOCH '%s': expected seconds since the epoch", epoch)
+	}
+	return time.Unix(secs, 0), nil
+}
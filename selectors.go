@@ -0,0 +1,90 @@
+package main
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/technosophos/dashing/docset"
+	"github.com/urfave/cli/v2"
+)
+
+// testSelectors runs the selectors against the given files and prints what
+// they match, without writing anything. The files are relative to the
+// source, as they are when building, so that matchpath sees the same paths.
+func testSelectors(c *cli.Context) error {
+	files := c.Args().Slice()
+	if len(files) == 0 {
+		return cli.Exit("No files given. Usage: dashing test-selectors FILE...", exitFailure)
+	}
+
+	dashing, err := loadDashing(c, newLogger(os.Stdout, levelInfo, false))
+	if err != nil {
+		return err
+	}
+	b, err := docset.NewBuilder(dashing)
+	if err != nil {
+		return cli.Exit(err.Error(), exitConfig)
+	}
+	fsys, closeSource, err := docset.OpenSource(sourceFlag(c))
+	if err != nil {
+		return cli.Exit(fmt.Sprintf("Cannot read source: %s", err), exitInput)
+	}
+	defer closeSource()
+	b.Source = fsys
+	showSkipped := c.Bool("skipped")
+
+	failed := false
+	for i, file := range files {
+		if i > 0 {
+			fmt.Println()
+		}
+		fmt.Printf("%s\n", file)
+		matches, err := testFile(b, fsys, file)
+		if err != nil {
+			fmt.Printf("Error parsing %s: %s\n", file, err)
+			failed = true
+			continue
+		}
+
+		w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(w, "LINE\tTYPE\tNAME\tANCHOR")
+		entries := 0
+		for _, m := range matches {
+			if m.Skipped != "" {
+				if showSkipped {
+					fmt.Fprintf(w, "%d\t%s\t%s\t(skipped: %s)\n", m.Line, m.Type, m.Name, m.Skipped)
+				}
+				continue
+			}
+			entries++
+			anchor := m.Path[strings.Index(m.Path, "#")+1:]
+			fmt.Fprintf(w, "%d\t%s\t%s\t#%s\n", m.Line, m.Type, m.Name, anchor)
+		}
+		w.Flush()
+		fmt.Printf("%d entries, %d skipped\n", entries, len(matches)-entries)
+	}
+	if failed {
+		return cli.Exit("", exitInput)
+	}
+	return nil
+}
+
+// testFile runs the selectors against a file in the source without
+// writing anything.
+func testFile(b *docset.Builder, fsys fs.FS, name string) ([]docset.Match, error) {
+	name = path.Clean(filepath.ToSlash(name))
+	if !fs.ValidPath(name) {
+		return nil, fmt.Errorf("%s is not inside the source", name)
+	}
+	r, err := fsys.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	return b.TestFile(name, r)
+}
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/technosophos/dashing/docset"
+	"github.com/urfave/cli/v2"
+)
+
+func validate(c *cli.Context) error {
+	cf := configFile(c.String("config"))
+	vars, err := configVars(c)
+	if err != nil {
+		return cli.Exit(err.Error(), exitConfig)
+	}
+	conf, err := docset.ReadConfig(cf, vars)
+	if os.IsNotExist(err) {
+		return cli.Exit(fmt.Sprintf("Failed to open configuration file '%s': %s (Run `dashing init`?)", cf, err), exitConfig)
+	} else if err != nil {
+		return cli.Exit(err.Error(), exitConfig)
+	}
+
+	source := sourceFlag(c)
+	fsys, closeSource, err := docset.OpenSource(source)
+	if err != nil {
+		return cli.Exit(fmt.Sprintf("Cannot read source: %s", err), exitInput)
+	}
+	defer closeSource()
+
+	problems := docset.Validate(conf, fsys)
+	for _, p := range problems {
+		fmt.Printf("%s: %s\n", cf, p)
+	}
+	if len(problems) > 0 {
+		fmt.Printf("Found %d problem(s) in %s\n", len(problems), cf)
+		return cli.Exit("", exitConfig)
+	}
+	fmt.Printf("%s is valid\n", cf)
+	return nil
+}